@@ -0,0 +1,218 @@
+package gokoo
+
+import (
+	"math/bits"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// minChunk is the smallest number of items worth handing to a goroutine when
+// a batch is split up.
+const minChunk = 4096
+
+// Bitset holds one bit for every item of a batch, which is set if the
+// operation succeeded for the item.
+type Bitset []uint64
+
+// newBitset will create a bitset for the given number of items.
+func newBitset(count int) Bitset {
+	return make(Bitset, (count+63)/64)
+}
+
+// Get will return the bit of the item at position k of the batch.
+func (bs Bitset) Get(k int) bool {
+	return bs[k/64]&(1<<uint(k%64)) != 0
+}
+
+// Count will return the number of bits that are set.
+func (bs Bitset) Count() int {
+
+	count := 0
+	for _, word := range bs {
+		count += bits.OnesCount64(word)
+	}
+
+	return count
+}
+
+// set will set the bit of the item at position k of the batch. Neighbouring
+// items may be handled by different goroutines, so we set it atomically.
+func (bs Bitset) set(k int) {
+	atomic.OrUint64(&bs[k/64], 1<<uint(k%64))
+}
+
+// hashed is an item along with its hash, so a batch can hash all its items
+// before touching the buckets.
+type hashed struct {
+	data []byte
+	hash uint64
+	gt   *GokooTable
+	i1   int
+	k    int
+}
+
+// of will return the hash of the item for the given table. A concurrent table
+// may be replaced while a batch is running, so we have to hash again if the
+// item was hashed for a different table.
+func (h *hashed) of(gt *GokooTable) uint64 {
+
+	if h.gt != gt {
+		h.hash = gt.hash64(h.data)
+		h.gt = gt
+	}
+
+	return h.hash
+}
+
+// prepare will hash the items, which start at the given position of the
+// batch, and order them by their primary bucket, so that we probe the buckets
+// in order of their memory location.
+func (gt *GokooTable) prepare(items []GokooItem, first int) []hashed {
+
+	hashes := make([]hashed, len(items))
+	for n, item := range items {
+		h := &hashes[n]
+		h.data = item.Bytes()
+		h.i1 = gt.primaryIndex(h.of(gt))
+		h.k = first + n
+	}
+
+	// group neighbouring buckets so there are about as many groups as items,
+	// which lets us order the items with a counting sort in linear time
+	shift := 0
+	for (gt.nBuckets-1)>>uint(shift) >= len(items) && shift < gt.iBits {
+		shift++
+	}
+	start := make([]int, (gt.nBuckets-1)>>uint(shift)+2)
+	for n := range hashes {
+		start[hashes[n].i1>>uint(shift)+1]++
+	}
+	for g := 1; g < len(start); g++ {
+		start[g] += start[g-1]
+	}
+	batch := make([]hashed, len(hashes))
+	for _, h := range hashes {
+		g := h.i1 >> uint(shift)
+		batch[start[g]] = h
+		start[g]++
+	}
+
+	return batch
+}
+
+// run will apply the operation to all items of the batch and record which of
+// them succeeded.
+func run(batch []hashed, op func(*hashed) bool, results Bitset) {
+	for n := range batch {
+		if op(&batch[n]) {
+			results.set(batch[n].k)
+		}
+	}
+}
+
+// failed will return the items whose bits are not set, in their original
+// order.
+func failed(items []GokooItem, results Bitset) []GokooItem {
+
+	var missing []GokooItem
+	for k, item := range items {
+		if !results.Get(k) {
+			missing = append(missing, item)
+		}
+	}
+
+	return missing
+}
+
+// InsertMany will try to add all items to the cuckoo table. The items are
+// hashed first and inserted in the order of their buckets, so items that fail
+// may differ from inserting them one by one. It returns the bitset of items
+// that were inserted, and the items that were not.
+func (gt *GokooTable) InsertMany(items []GokooItem) (Bitset, []GokooItem) {
+
+	results := newBitset(len(items))
+	run(gt.prepare(items, 0), gt.insert, results)
+
+	return results, failed(items, results)
+}
+
+// LookupMany will check which of the items the cuckoo table contains, and
+// return the bitset of items that were found.
+func (gt *GokooTable) LookupMany(items []GokooItem) Bitset {
+
+	results := newBitset(len(items))
+	run(gt.prepare(items, 0), gt.lookup, results)
+
+	return results
+}
+
+// RemoveMany will remove the items from the cuckoo table, and return the
+// bitset of items that were removed.
+func (gt *GokooTable) RemoveMany(items []GokooItem) Bitset {
+
+	results := newBitset(len(items))
+	run(gt.prepare(items, 0), gt.remove, results)
+
+	return results
+}
+
+// batch will apply the operation to all items, splitting large batches into
+// chunks that are hashed and probed by separate goroutines.
+func (ct *ConcurrentTable) batch(items []GokooItem,
+	op func(*hashed) bool) Bitset {
+
+	// use as many goroutines as we can run at once, if they get enough items
+	results := newBitset(len(items))
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(items)/minChunk {
+		workers = len(items) / minChunk
+	}
+	if workers <= 1 {
+		run(ct.ptr.Load().prepare(items, 0), op, results)
+		return results
+	}
+
+	// every goroutine handles a contiguous chunk of the items
+	size := (len(items) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for first := 0; first < len(items); first += size {
+		last := first + size
+		if last > len(items) {
+			last = len(items)
+		}
+		wg.Add(1)
+		go func(first int, last int) {
+			defer wg.Done()
+			batch := ct.ptr.Load().prepare(items[first:last], first)
+			run(batch, op, results)
+		}(first, last)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// InsertMany will try to add all items to the cuckoo table, spreading large
+// batches over several goroutines. It returns the bitset of items that were
+// inserted, and the items that were not.
+func (ct *ConcurrentTable) InsertMany(items []GokooItem) (Bitset, []GokooItem) {
+
+	results := ct.batch(items, ct.insert)
+
+	return results, failed(items, results)
+}
+
+// LookupMany will check which of the items the cuckoo table contains,
+// spreading large batches over several goroutines, and return the bitset of
+// items that were found.
+func (ct *ConcurrentTable) LookupMany(items []GokooItem) Bitset {
+	return ct.batch(items, ct.lookup)
+}
+
+// RemoveMany will remove the items from the cuckoo table, spreading large
+// batches over several goroutines, and return the bitset of items that were
+// removed.
+func (ct *ConcurrentTable) RemoveMany(items []GokooItem) Bitset {
+	return ct.batch(items, ct.remove)
+}
@@ -0,0 +1,125 @@
+package gokoo
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+)
+
+func TestBitset(t *testing.T) {
+
+	// set some bits across word boundaries
+	bs := newBitset(130)
+	if len(bs) != 3 {
+		t.Errorf("wrong bitset length: %v", len(bs))
+	}
+	for _, k := range []int{0, 63, 64, 129} {
+		bs.set(k)
+	}
+	for k := 0; k < 130; k++ {
+		set := k == 0 || k == 63 || k == 64 || k == 129
+		if bs.Get(k) != set {
+			t.Errorf("wrong bit at %v: %v", k, bs.Get(k))
+		}
+	}
+	if bs.Count() != 4 {
+		t.Errorf("wrong bit count: %v", bs.Count())
+	}
+}
+
+func TestHashed(t *testing.T) {
+
+	// an item is only hashed again for a different table
+	gt1, _ := New(SetSipHashKeys(1, 2))
+	gt2, _ := New(SetSipHashKeys(3, 4))
+	h := &hashed{data: []byte("gokoo")}
+	hash := h.of(gt1)
+	h.data = nil
+	if h.of(gt1) != hash {
+		t.Errorf("item hashed again for the same table")
+	}
+	h.data = []byte("gokoo")
+	if h.of(gt2) == hash || h.of(gt2) != gt2.hash64(h.data) {
+		t.Errorf("item not hashed again for another table")
+	}
+}
+
+func TestBatch(t *testing.T) {
+
+	// insert a batch into a table that has enough room
+	count := 10000
+	items := benchItems(0, count)
+	absent := benchItems(1<<40, count)
+	gt, _, _ := NewForCapacity(count, 0.01, SetHashFunc(SipHash))
+	inserted, missing := gt.InsertMany(items)
+	if inserted.Count() != count || len(missing) != 0 || gt.Count() != count {
+		t.Fatalf("insert error: %v inserted, %v failed", inserted.Count(),
+			len(missing))
+	}
+
+	// batch lookups must give the same results as single ones
+	found := gt.LookupMany(items)
+	if found.Count() != count {
+		t.Errorf("lookup error: %v found", found.Count())
+	}
+	found = gt.LookupMany(absent)
+	for k, item := range absent {
+		if found.Get(k) != gt.Lookup(item) {
+			t.Errorf("wrong batch lookup result for item %v", k)
+		}
+	}
+
+	// remove all items again
+	removed := gt.RemoveMany(items)
+	if removed.Count() != count || gt.Count() != 0 {
+		t.Errorf("delete error: %v removed", removed.Count())
+	}
+
+	// insert more items than fit, the failed ones are those without a bit
+	gt, _ = New(SetHashFunc(SipHash), SetNumBuckets(64), SetNumTries(32))
+	inserted, missing = gt.InsertMany(items[:500])
+	if len(missing) == 0 || inserted.Count()+len(missing) != 500 ||
+		gt.Count() != inserted.Count() {
+		t.Errorf("wrong results for overfull table: %v inserted, %v failed",
+			inserted.Count(), len(missing))
+	}
+	n := 0
+	for k, item := range items[:500] {
+		if inserted.Get(k) {
+			continue
+		}
+		if !bytes.Equal(missing[n].Bytes(), item.Bytes()) {
+			t.Errorf("wrong failed item at %v", n)
+		}
+		n++
+	}
+}
+
+func TestConcurrentBatch(t *testing.T) {
+
+	// make sure the batches are split, even on a single CPU
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	// insert, lookup and remove a batch large enough to be split
+	count := 8 * minChunk
+	items := benchItems(0, count)
+	ct, _ := NewConcurrent(
+		SetHashFunc(SipHash),
+		SetNumBuckets(count/2),
+		SetStashSize(4),
+		SetRebuild(true),
+	)
+	inserted, missing := ct.InsertMany(items)
+	if inserted.Count() != count || len(missing) != 0 {
+		t.Fatalf("insert error: %v inserted, %v failed", inserted.Count(),
+			len(missing))
+	}
+	found := ct.LookupMany(items)
+	if found.Count() != count {
+		t.Errorf("lookup error: %v found", found.Count())
+	}
+	removed := ct.RemoveMany(items)
+	if removed.Count() != count || ct.Count() != 0 {
+		t.Errorf("delete error: %v removed", removed.Count())
+	}
+}
@@ -0,0 +1,253 @@
+package gokoo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// benchItems will create count distinct items, starting at the given id.
+func benchItems(first int, count int) []GokooItem {
+
+	data := make([]byte, 16*count)
+	items := make([]GokooItem, count)
+	for n := range items {
+		item := data[16*n : 16*n+16]
+		binary.LittleEndian.PutUint64(item, uint64(first+n))
+		binary.LittleEndian.PutUint64(item[8:], uint64(first+n)*0x9e3779b97f4a7c15)
+		items[n] = byteItem(item)
+	}
+
+	return items
+}
+
+// parameters of the tables we benchmark
+var (
+	benchHashes = []struct {
+		name string
+		hash GokooHash
+	}{
+		{"dummy", DummyHash},
+		{"sha256", Sha256Hash},
+		{"sip", SipHash},
+	}
+	benchBuckets = []int{1 << 10, 1 << 16}
+	benchBits    = []int{8, 12, 16}
+	benchLoads   = []float64{0.25, 0.5, 0.9}
+)
+
+// benchTable will create a table and fill it to the given load factor. It
+// returns the table and all items that were inserted.
+func benchTable(b *testing.B, hash GokooHash, nBuckets int, fBits int,
+	load float64) (*GokooTable, []GokooItem) {
+
+	gt, err := New(
+		SetHashFunc(hash),
+		SetNumBuckets(nBuckets),
+		SetFingerprintBits(fBits),
+	)
+	if err != nil {
+		b.Fatalf("could not create table: %v", err)
+	}
+
+	// keep only the items that actually made it into the table
+	items := benchItems(0, int(load*float64(gt.Capacity())))
+	inserted := items[:0]
+	for _, item := range items {
+		if gt.Insert(item) {
+			inserted = append(inserted, item)
+		}
+	}
+
+	return gt, inserted
+}
+
+// benchConfigs will run a benchmark for every combination of hash function,
+// table size, fingerprint bits and load factor. The names of the benchmarks
+// consist of key=value pairs, so benchstat can compare them by parameter.
+func benchConfigs(b *testing.B,
+	run func(b *testing.B, gt *GokooTable, items []GokooItem)) {
+
+	for _, h := range benchHashes {
+		for _, nBuckets := range benchBuckets {
+			for _, fBits := range benchBits {
+				for _, load := range benchLoads {
+					name := fmt.Sprintf("hash=%v/buckets=%v/bits=%v/load=%v",
+						h.name, nBuckets, fBits, load)
+					b.Run(name, func(b *testing.B) {
+						gt, items := benchTable(b, h.hash, nBuckets, fBits, load)
+						bits := float64(8*len(gt.buckets)) / float64(len(items))
+						b.ReportMetric(bits, "bits/item")
+						b.ReportAllocs()
+						b.ResetTimer()
+						run(b, gt, items)
+					})
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkInsert(b *testing.B) {
+	benchConfigs(b, func(b *testing.B, gt *GokooTable, items []GokooItem) {
+
+		// insert batches of new items, and remove them again between batches
+		// so the load factor stays within one percent of the configured one
+		batch := gt.Capacity()/100 + 1
+		extra := benchItems(1<<40, batch)
+		inserted := make([]bool, batch)
+		for n := 0; n < b.N; n++ {
+			k := n % batch
+			if k == 0 && n > 0 {
+				b.StopTimer()
+				for j, item := range extra {
+					if inserted[j] {
+						gt.Remove(item)
+					}
+				}
+				b.StartTimer()
+			}
+			inserted[k] = gt.Insert(extra[k])
+		}
+	})
+}
+
+func BenchmarkLookup(b *testing.B) {
+	benchConfigs(b, func(b *testing.B, gt *GokooTable, items []GokooItem) {
+
+		// look up items that are in the table
+		for n := 0; n < b.N; n++ {
+			gt.Lookup(items[n%len(items)])
+		}
+	})
+}
+
+func BenchmarkLookupMany(b *testing.B) {
+	benchConfigs(b, func(b *testing.B, gt *GokooTable, items []GokooItem) {
+
+		// look up all items as one batch, counting each item as an operation
+		for n := 0; n < b.N; n += len(items) {
+			batch := items
+			if b.N-n < len(batch) {
+				batch = batch[:b.N-n]
+			}
+			gt.LookupMany(batch)
+		}
+	})
+}
+
+func BenchmarkLookupAbsent(b *testing.B) {
+	benchConfigs(b, func(b *testing.B, gt *GokooTable, items []GokooItem) {
+
+		// look up items that were never inserted
+		absent := benchItems(1<<40, 1024)
+		for n := 0; n < b.N; n++ {
+			gt.Lookup(absent[n%len(absent)])
+		}
+	})
+}
+
+func BenchmarkRemove(b *testing.B) {
+	benchConfigs(b, func(b *testing.B, gt *GokooTable, items []GokooItem) {
+
+		// remove batches of items, and insert them again between batches so
+		// the load factor stays within one percent of the configured one
+		batch := gt.Capacity()/100 + 1
+		if batch > len(items) {
+			batch = len(items)
+		}
+		for n := 0; n < b.N; n++ {
+			k := n % batch
+			if k == 0 && n > 0 {
+				b.StopTimer()
+				for _, item := range items[:batch] {
+					gt.Insert(item)
+				}
+				b.StartTimer()
+			}
+			gt.Remove(items[k])
+		}
+	})
+}
+
+// fpTable will create a table for measuring false positives and fill it as
+// far as possible.
+func fpTable(tb testing.TB, nSlots int, fBits int) *GokooTable {
+
+	gt, err := New(
+		SetHashFunc(SipHash),
+		SetNumBuckets(1<<12),
+		SetNumSlots(nSlots),
+		SetFingerprintBits(fBits),
+	)
+	if err != nil {
+		tb.Fatalf("could not create table: %v", err)
+	}
+	for _, item := range benchItems(0, gt.Capacity()) {
+		gt.Insert(item)
+	}
+
+	return gt
+}
+
+func BenchmarkFalsePositiveRate(b *testing.B) {
+	for _, nSlots := range []int{2, 4, 8} {
+		for _, fBits := range benchBits {
+			name := fmt.Sprintf("slots=%v/bits=%v", nSlots, fBits)
+			b.Run(name, func(b *testing.B) {
+
+				// look up items that were never inserted
+				gt := fpTable(b, nSlots, fBits)
+				absent := benchItems(1<<40, b.N)
+				b.ResetTimer()
+				positives := 0
+				for _, item := range absent {
+					if gt.Lookup(item) {
+						positives++
+					}
+				}
+
+				// report the measured rate next to the estimate and the bound
+				b.ReportMetric(float64(positives)/float64(b.N), "fpr")
+				b.ReportMetric(gt.Stats().FPRate, "fpr-estimate")
+				b.ReportMetric(estimateFPRate(nSlots, fBits, 1), "fpr-bound")
+				b.ReportMetric(gt.LoadFactor(), "load")
+			})
+		}
+	}
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+
+	// check the settings with a rate high enough to measure quickly
+	count := 100000
+	absent := benchItems(1<<40, count)
+	for _, nSlots := range []int{2, 4} {
+		for _, fBits := range []int{8, 12} {
+
+			// count the false positives
+			gt := fpTable(t, nSlots, fBits)
+			positives := 0
+			for _, item := range absent {
+				if gt.Lookup(item) {
+					positives++
+				}
+			}
+
+			// the measured rate must not be significantly above the bound, and
+			// close to the estimate for the current load
+			rate := float64(positives) / float64(count)
+			bound := estimateFPRate(nSlots, fBits, 1)
+			estimate := gt.Stats().FPRate
+			if rate > 1.2*bound {
+				t.Errorf("false positive rate above bound for %v slots and %v"+
+					" bits: %v > %v", nSlots, fBits, rate, bound)
+			}
+			if math.Abs(rate-estimate) > 0.2*estimate+10/float64(count) {
+				t.Errorf("false positive rate far from estimate for %v slots"+
+					" and %v bits: %v != %v", nSlots, fBits, rate, estimate)
+			}
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package gokoo
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// packedLen will return the number of bytes needed to store count values of
+// the given bit width in a bit-packed array.
+func packedLen(count int, width int) int {
+	return (count*width + 7) / 8
+}
+
+// getBits will return the value at position k of a bit-packed array of values
+// with the given bit width.
+func getBits(data []byte, k int, width int) uint32 {
+
+	// get the position of the first bit and its offset within the byte
+	bit := k * width
+	shift := uint(bit % 8)
+
+	// collect all bytes the value is spread over
+	var v uint64
+	for n, b := bit/8, uint(0); b < shift+uint(width); n, b = n+1, b+8 {
+		v |= uint64(data[n]) << b
+	}
+
+	// cut out the bits belonging to the value
+	return uint32(v >> shift & (1<<uint(width) - 1))
+}
+
+// setBits will store the value at position k of a bit-packed array of values
+// with the given bit width.
+func setBits(data []byte, k int, width int, value uint32) {
+
+	// get the position of the first bit and its offset within the byte
+	bit := k * width
+	shift := uint(bit % 8)
+
+	// shift the value and a mask for its bits into position
+	mask := (uint64(1)<<uint(width) - 1) << shift
+	v := uint64(value) << shift & mask
+
+	// replace the bits of the value in all bytes it is spread over
+	for n, b := bit/8, uint(0); b < shift+uint(width); n, b = n+1, b+8 {
+		data[n] = data[n]&^byte(mask>>b) | byte(v>>b)
+	}
+}
+
+// allocate will create the empty storage for all buckets, either bit-packed or
+// as atomic words.
+func (gt *GokooTable) allocate() {
+
+	if !gt.lockFree {
+		gt.buckets = make([]byte, packedLen(gt.nBuckets*gt.nSlots, gt.sBits))
+		return
+	}
+
+	gt.words = make([]uint64, gt.nBuckets*gt.nWords)
+	gt.versions = make([]uint64, gt.nBuckets)
+}
+
+// packed will return the fingerprints of all slots as bit-packed array.
+func (gt *GokooTable) packed() []byte {
+
+	if !gt.lockFree {
+		return gt.buckets
+	}
+
+	data := make([]byte, packedLen(gt.nBuckets*gt.nSlots, gt.sBits))
+	for i := 0; i < gt.nBuckets; i++ {
+		b := gt.bucket(i)
+		for n := 0; n < gt.nSlots; n++ {
+			setBits(data, b.first+n, gt.sBits, b.get(n))
+		}
+	}
+
+	return data
+}
+
+// unpack will fill all slots from a bit-packed array of fingerprints.
+func (gt *GokooTable) unpack(data []byte) {
+
+	if !gt.lockFree {
+		gt.buckets = data
+		return
+	}
+
+	gt.allocate()
+	for i := 0; i < gt.nBuckets; i++ {
+		b := gt.bucket(i)
+		for n := 0; n < gt.nSlots; n++ {
+			b.set(n, getBits(data, b.first+n, gt.sBits))
+		}
+	}
+}
+
+// bucket gives access to the slots of a single bucket of the table.
+type bucket struct {
+	gt    *GokooTable
+	i     int
+	first int
+}
+
+// bucket will return the bucket with index i.
+func (gt *GokooTable) bucket(i int) bucket {
+	return bucket{gt: gt, i: i, first: i * gt.nSlots}
+}
+
+// word will return the position of the word holding slot n of an atomic
+// bucket, and the offset of the slot within it.
+func (b bucket) word(n int) (int, uint) {
+	w := b.i*b.gt.nWords + n/b.gt.nPerWord
+	shift := uint(n % b.gt.nPerWord * b.gt.sBits)
+	return w, shift
+}
+
+// used will check if slot n is occupied, which is the case for any non-zero
+// fingerprint.
+func (b bucket) used(n int) bool {
+	return b.get(n) != 0
+}
+
+// get will return the fingerprint in slot n, along with its counter in
+// counting mode.
+func (b bucket) get(n int) uint32 {
+
+	if !b.gt.lockFree {
+		return getBits(b.gt.buckets, b.first+n, b.gt.sBits)
+	}
+
+	w, shift := b.word(n)
+	v := atomic.LoadUint64(&b.gt.words[w])
+	return uint32(v >> shift & (1<<uint(b.gt.sBits) - 1))
+}
+
+// set will save fingerprint f in slot n.
+func (b bucket) set(n int, f uint32) {
+
+	if !b.gt.lockFree {
+		setBits(b.gt.buckets, b.first+n, b.gt.sBits, f)
+		return
+	}
+
+	// replace the slot without touching the other slots of the word
+	w, shift := b.word(n)
+	mask := (uint64(1)<<uint(b.gt.sBits) - 1) << shift
+	for {
+		old := atomic.LoadUint64(&b.gt.words[w])
+		v := old&^mask | uint64(f)<<shift
+		if atomic.CompareAndSwapUint64(&b.gt.words[w], old, v) {
+			return
+		}
+	}
+}
+
+// clear will mark slot n as free by setting the empty fingerprint.
+func (b bucket) clear(n int) {
+	b.set(n, 0)
+}
+
+// version will return the version of an atomic bucket, which is odd while a
+// fingerprint is being moved out of it.
+func (b bucket) version() uint64 {
+
+	if b.gt.versions == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&b.gt.versions[b.i])
+}
+
+// begin will mark an atomic bucket as being modified, unless it already is.
+func (b bucket) begin() {
+
+	if b.gt.versions == nil {
+		return
+	}
+
+	v := &b.gt.versions[b.i]
+	if atomic.LoadUint64(v)%2 == 0 {
+		atomic.AddUint64(v, 1)
+	}
+}
+
+// end will mark an atomic bucket as stable again, unless it already is.
+func (b bucket) end() {
+
+	if b.gt.versions == nil {
+		return
+	}
+
+	v := &b.gt.versions[b.i]
+	if atomic.LoadUint64(v)%2 == 1 {
+		atomic.AddUint64(v, 1)
+	}
+}
+
+// free will return the first free slot, or -1 if the bucket is full.
+func (b bucket) free() int {
+
+	for n := 0; n < b.gt.nSlots; n++ {
+		if !b.used(n) {
+			return n
+		}
+	}
+
+	return -1
+}
+
+// load will return the number of occupied slots.
+func (b bucket) load() int {
+
+	k := 0
+	for n := 0; n < b.gt.nSlots; n++ {
+		if b.used(n) {
+			k++
+		}
+	}
+
+	return k
+}
+
+// find will return the first slot that holds fingerprint f, or -1 if there is
+// none.
+func (b bucket) find(f uint32) int {
+
+	// fingerprints are never zero, so we can't match free slots
+	for n := 0; n < b.gt.nSlots; n++ {
+		if b.gt.fingerPrintOf(b.get(n)) == f {
+			return n
+		}
+	}
+
+	return -1
+}
+
+// settle will mark all buckets touched by an eviction chain as stable again.
+func (gt *GokooTable) settle(undo []eviction) {
+
+	for _, e := range undo {
+		gt.bucket(e.bucket).end()
+	}
+}
+
+// hasPair will check if either of two buckets contains fingerprint f. For
+// atomic buckets, this can run concurrently with writers without locks: a
+// fingerprint that was found is always valid, but if we don't find it, we
+// have to make sure it wasn't in the middle of being moved between buckets.
+func (gt *GokooTable) hasPair(i1 int, i2 int, f uint32) bool {
+
+	b1, b2 := gt.bucket(i1), gt.bucket(i2)
+	for {
+
+		// remember the versions before we read the buckets
+		v1, v2 := b1.version(), b2.version()
+		if b1.find(f) >= 0 || b2.find(f) >= 0 {
+			return true
+		}
+
+		// the result is only valid if neither bucket was being modified
+		if v1%2 == 0 && v2%2 == 0 && b1.version() == v1 && b2.version() == v2 {
+			return false
+		}
+
+		// give the writer a chance to finish before we try again
+		runtime.Gosched()
+	}
+}
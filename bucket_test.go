@@ -0,0 +1,212 @@
+package gokoo
+
+import (
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+func TestBits(t *testing.T) {
+
+	// check all supported widths
+	for width := 4; width <= 32; width++ {
+
+		// fill a bit-packed array with random values
+		count := 100
+		mask := uint32(1<<uint(width) - 1)
+		values := make([]uint32, count)
+		data := make([]byte, packedLen(count, width))
+		for k := range values {
+			values[k] = rand.Uint32() & mask
+			setBits(data, k, width, values[k])
+		}
+
+		// overwrite every other value, which must not touch its neighbours
+		for k := 0; k < count; k += 2 {
+			values[k] = ^values[k] & mask
+			setBits(data, k, width, values[k])
+		}
+
+		// check that we get back exactly what we stored
+		for k, value := range values {
+			v := getBits(data, k, width)
+			if v != value {
+				t.Errorf("wrong value for width %v at %v: %x != %x",
+					width, k, v, value)
+			}
+		}
+	}
+}
+
+func TestBucket(t *testing.T) {
+
+	gt, _ := New(SetNumSlots(3), SetFingerprintBits(7))
+	b := gt.bucket(5)
+
+	// fill all slots of the bucket
+	for n := 0; n < gt.nSlots; n++ {
+		if b.free() != n {
+			t.Errorf("wrong free slot: %v != %v", b.free(), n)
+		}
+		b.set(n, uint32(n+1))
+	}
+	if b.free() != -1 {
+		t.Errorf("full bucket has free slot %v", b.free())
+	}
+
+	// find and clear a fingerprint
+	n := b.find(2)
+	if n != 1 {
+		t.Errorf("wrong slot for fingerprint: %v", n)
+	}
+	b.clear(n)
+	if b.find(2) != -1 {
+		t.Errorf("cleared fingerprint still found")
+	}
+	if b.free() != 1 {
+		t.Errorf("cleared slot not free")
+	}
+
+	// neighbouring buckets must not be affected
+	if gt.bucket(4).free() != 0 || gt.bucket(6).free() != 0 {
+		t.Errorf("neighbouring bucket modified")
+	}
+}
+
+func TestFingerprintBits(t *testing.T) {
+
+	// check widths that are not a multiple of eight
+	count := 100
+	items := randomItems(t, count)
+	for _, fBits := range []int{4, 7, 12, 17, 32} {
+
+		// insert all items
+		cf, _ := New(
+			SetHashFunc(SipHash),
+			SetNumBuckets(64),
+			SetFingerprintBits(fBits),
+		)
+		for _, item := range items {
+			if !cf.Insert(item) {
+				t.Errorf("insert error for %v bits", fBits)
+			}
+		}
+
+		// lookup and delete all items
+		for _, item := range items {
+			if !cf.Lookup(item) {
+				t.Errorf("lookup error for %v bits", fBits)
+			}
+		}
+		for _, item := range items {
+			if !cf.Remove(item) {
+				t.Errorf("delete error for %v bits", fBits)
+			}
+		}
+	}
+}
+
+func TestAtomicBuckets(t *testing.T) {
+
+	// atomic buckets can't be rebuilt
+	_, err := New(SetAtomicBuckets(true), SetRebuild(true))
+	if err == nil {
+		t.Errorf("could construct atomic buckets with rebuild")
+	}
+
+	// slots must not cross words, so 12 bit fingerprints fit five per word
+	gt, err := New(
+		SetAtomicBuckets(true),
+		SetNumBuckets(64),
+		SetNumSlots(6),
+		SetFingerprintBits(12),
+	)
+	if err != nil {
+		t.Fatalf("could not construct with atomic buckets: %v", err)
+	}
+	if gt.nPerWord != 5 || gt.nWords != 2 || len(gt.words) != 128 {
+		t.Errorf("wrong word layout: %v slots per word, %v words per bucket",
+			gt.nPerWord, gt.nWords)
+	}
+
+	// check that items can be inserted, found and removed
+	count := 200
+	items := randomItems(t, count)
+	for _, item := range items {
+		if !gt.Insert(item) {
+			t.Errorf("insert error")
+		}
+	}
+	for _, item := range items {
+		if !gt.Lookup(item) {
+			t.Errorf("lookup error")
+		}
+	}
+
+	// check that atomic buckets survive a serialization round trip
+	data, _ := gt.MarshalBinary()
+	gt2, _ := New()
+	err = gt2.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf("could not unmarshal atomic buckets: %v", err)
+	}
+	if !gt2.lockFree || gt2.buckets != nil {
+		t.Errorf("atomic buckets not restored")
+	}
+	for _, item := range items {
+		if !gt2.Lookup(item) {
+			t.Errorf("lookup error after unmarshal")
+		}
+	}
+
+	// all items must be removable
+	for _, item := range items {
+		if !gt.Remove(item) {
+			t.Errorf("delete error")
+		}
+	}
+	if gt.Count() != 0 {
+		t.Errorf("wrong count after delete: %v", gt.Count())
+	}
+}
+
+func TestVersions(t *testing.T) {
+
+	gt, _ := New(SetAtomicBuckets(true))
+	b := gt.bucket(3)
+
+	// marking a bucket twice only counts once
+	b.begin()
+	b.begin()
+	if b.version() != 1 {
+		t.Errorf("wrong version while modifying: %v", b.version())
+	}
+	b.end()
+	b.end()
+	if b.version() != 2 {
+		t.Errorf("wrong version after modifying: %v", b.version())
+	}
+
+	// a reader must not report a miss while a fingerprint is being moved
+	// between its buckets, but wait until it has arrived
+	b1, b2 := gt.bucket(1), gt.bucket(2)
+	b1.begin()
+	result := make(chan bool)
+	go func() {
+		result <- gt.hasPair(1, 2, 7)
+	}()
+	runtime.Gosched()
+	b2.set(0, 7)
+	b1.end()
+	if !<-result {
+		t.Errorf("miss reported during move")
+	}
+
+	// bit-packed buckets have no versions
+	gt, _ = New()
+	b = gt.bucket(3)
+	b.begin()
+	if b.version() != 0 {
+		t.Errorf("bit-packed bucket has a version")
+	}
+}
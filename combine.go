@@ -0,0 +1,194 @@
+package gokoo
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// compatible will check that fingerprints of the other table mean the same in
+// this one, which needs the same buckets, fingerprints and hash function. The
+// number of slots may differ, as tables that rebuild grow their slots.
+func (gt *GokooTable) compatible(other *GokooTable) error {
+
+	if other == gt {
+		return errors.New("can not combine a table with itself")
+	}
+
+	if other.nBuckets != gt.nBuckets {
+		return errors.New("tables have different numbers of buckets")
+	}
+
+	if other.fBits != gt.fBits {
+		return errors.New("tables have different fingerprint bits")
+	}
+
+	// keyed hash functions are only the same with the same keys, while other
+	// functions can only be compared by their code, which can't tell apart
+	// closures over different state, so we only accept bundled ones
+	if gt.keyed || other.keyed {
+		if !gt.keyed || !other.keyed || gt.k0 != other.k0 ||
+			gt.k1 != other.k1 {
+			return errors.New("tables use different hash functions")
+		}
+		return nil
+	}
+	id := hashID(gt.hash64)
+	if id == hashCustom || hashID(other.hash64) == hashCustom {
+		return errors.New("can not compare custom hash functions of tables")
+	}
+	if id != hashID(other.hash64) {
+		return errors.New("tables use different hash functions")
+	}
+
+	return nil
+}
+
+// Merge will insert all fingerprints of the other table into this one, so it
+// contains the items of both. The tables need the same number of buckets,
+// fingerprint bits and hash function, which has to be a bundled one or SipHash
+// with keys set by option. If the table runs full, the merge fails with an
+// error and the table is left as it was.
+func (gt *GokooTable) Merge(other *GokooTable) error {
+
+	err := gt.compatible(other)
+	if err != nil {
+		return err
+	}
+
+	// merge into a copy, so we can drop it if the table runs full; every
+	// fingerprint belongs to the same bucket pair in both tables, and all its
+	// copies have to be inserted in counting mode
+	tmp := gt.clone()
+	for _, sv := range other.stored(0, other.nBuckets, true) {
+		f := other.fingerPrintOf(sv.v)
+		for c := other.copies(sv.v); c > 0; c-- {
+			if !tmp.insertAt(sv.bucket, f) {
+				return errors.New("table is full")
+			}
+		}
+	}
+
+	// everything was merged successfully, so take over the new table
+	*gt = *tmp
+	return nil
+}
+
+// clone will return a copy of the table that shares no storage with it, only
+// the hash function and random source.
+func (gt *GokooTable) clone() *GokooTable {
+
+	tmp := *gt
+	tmp.buckets = append([]byte(nil), gt.buckets...)
+	tmp.words = append([]uint64(nil), gt.words...)
+	tmp.versions = append([]uint64(nil), gt.versions...)
+	tmp.stash = append([]victim(nil), gt.stash...)
+	tmp.fill = append([]int64(nil), gt.fill...)
+
+	return &tmp
+}
+
+// Intersect will remove all fingerprints from the table that the other table
+// doesn't contain for the same pair of buckets, so it approximately contains
+// the items that are in both. The tables need to be compatible as for Merge.
+func (gt *GokooTable) Intersect(other *GokooTable) error {
+	return gt.retain(other, true)
+}
+
+// Difference will remove all fingerprints from the table that the other table
+// contains for the same pair of buckets, so it approximately contains the
+// items that are not in the other one. The tables need to be compatible as
+// for Merge.
+func (gt *GokooTable) Difference(other *GokooTable) error {
+	return gt.retain(other, false)
+}
+
+// pair identifies a fingerprint along with the pair of buckets it belongs to,
+// by the lower of the two indexes.
+type pair struct {
+	i int
+	f uint32
+}
+
+// retain will keep only the copies of each fingerprint that are shared with
+// the other table, or only those that aren't.
+func (gt *GokooTable) retain(other *GokooTable, shared bool) error {
+
+	err := gt.compatible(other)
+	if err != nil {
+		return err
+	}
+
+	// each copy in the other table can only match one copy in ours, so we
+	// keep track of how many are left for every fingerprint
+	left := make(map[pair]int)
+	keep := func(i int, v uint32) int {
+
+		f := gt.fingerPrintOf(v)
+		i2 := gt.secondaryIndex(i, f)
+		p := pair{i: min(i, i2), f: f}
+		n, ok := left[p]
+		if !ok {
+			n = other.countPair(i, i2, f) + other.countStash(i, i2, f)
+		}
+
+		c := gt.copies(v)
+		matched := min(c, n)
+		left[p] = n - matched
+		if shared {
+			return matched
+		}
+		return c - matched
+	}
+
+	// go through the buckets first
+	for i := 0; i < gt.nBuckets; i++ {
+		b := gt.bucket(i)
+		for n := 0; n < gt.nSlots; n++ {
+			if b.used(n) {
+				gt.setCopies(b, n, keep(i, b.get(n)))
+			}
+		}
+	}
+
+	// then through the stash, which can now move to the slots we freed
+	stash := gt.stash[:0]
+	for _, vic := range gt.stash {
+		c := gt.copies(vic.f)
+		k := keep(vic.bucket, vic.f)
+		atomic.AddInt64(&gt.nDups, int64(max(k, 1)-c))
+		if k == 0 {
+			continue
+		}
+		vic.f = gt.fingerPrintOf(vic.f) | uint32(k-1)<<uint(gt.fBits)
+		stash = append(stash, vic)
+	}
+	gt.setStash(stash)
+	gt.drain()
+
+	return nil
+}
+
+// setCopies will change the number of copies of the fingerprint in slot n of
+// bucket b, and free the slot if there are none left.
+func (gt *GokooTable) setCopies(b bucket, n int, k int) {
+
+	v := b.get(n)
+	c := gt.copies(v)
+	if k == c {
+		return
+	}
+
+	// keep the remaining copies
+	atomic.AddInt64(&gt.nDups, int64(max(k, 1)-c))
+	if k > 0 {
+		b.set(n, gt.fingerPrintOf(v)|uint32(k-1)<<uint(gt.fBits))
+		return
+	}
+
+	// keep track of the bucket fill levels
+	l := b.load()
+	atomic.AddInt64(&gt.fill[l], -1)
+	atomic.AddInt64(&gt.fill[l-1], 1)
+	atomic.AddInt64(&gt.nItems, -1)
+	b.clear(n)
+}
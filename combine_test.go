@@ -0,0 +1,233 @@
+package gokoo
+
+import (
+	"bytes"
+	"testing"
+)
+
+// combineTables will create two compatible tables, the first holding items 0
+// to 99 and the second holding items 50 to 149.
+func combineTables(t *testing.T, options ...func(*GokooTable)) (*GokooTable,
+	*GokooTable, []GokooItem) {
+
+	options = append([]func(*GokooTable){SetHashFunc(SipHash),
+		SetNumBuckets(64), SetFingerprintBits(16), SetStashSize(4)},
+		options...)
+	items := benchItems(0, 150)
+	tables := make([]*GokooTable, 2)
+	for k := range tables {
+		gt, err := New(options...)
+		if err != nil {
+			t.Fatalf("could not create table: %v", err)
+		}
+		for _, item := range items[k*50 : k*50+100] {
+			if !gt.Insert(item) {
+				t.Fatalf("insert error")
+			}
+		}
+		tables[k] = gt
+	}
+
+	return tables[0], tables[1], items
+}
+
+// checkItems will check that exactly the items between first and last are in
+// the table.
+func checkItems(t *testing.T, gt *GokooTable, items []GokooItem, first int,
+	last int) {
+
+	for k, item := range items {
+		if gt.Lookup(item) != (k >= first && k < last) {
+			t.Errorf("wrong lookup result for item %v", k)
+		}
+	}
+}
+
+// checkCount will check the number of items in the table, both as counted
+// along the way and as counted from scratch.
+func checkCount(t *testing.T, gt *GokooTable, count int) {
+
+	if gt.Count() != count {
+		t.Errorf("wrong count: %v != %v", gt.Count(), count)
+	}
+	gt.recount()
+	if gt.Count() != count {
+		t.Errorf("wrong count from scratch: %v != %v", gt.Count(), count)
+	}
+}
+
+func TestMerge(t *testing.T) {
+
+	// all items of both tables must be found afterwards
+	gt, other, items := combineTables(t)
+	err := gt.Merge(other)
+	if err != nil {
+		t.Fatalf("could not merge tables: %v", err)
+	}
+	checkItems(t, gt, items, 0, 150)
+	checkCount(t, gt, 200)
+
+	// the other table must stay as it is
+	checkItems(t, other, items, 50, 150)
+	if other.Count() != 100 {
+		t.Errorf("other table changed: %v", other.Count())
+	}
+
+	// merging into a table that is too small must fail
+	small, err := New(SetHashFunc(SipHash), SetNumBuckets(64),
+		SetFingerprintBits(16), SetNumSlots(1))
+	if err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+	for _, item := range items[:40] {
+		small.Insert(item)
+	}
+	count := small.Count()
+	data, _ := small.MarshalBinary()
+	if small.Merge(gt) == nil {
+		t.Errorf("merge into full table did not fail")
+	}
+
+	// and leave it unchanged
+	after, _ := small.MarshalBinary()
+	if small.Count() != count || !bytes.Equal(data, after) {
+		t.Errorf("failed merge changed the table")
+	}
+	checkCount(t, small, count)
+
+	// unless it can rebuild
+	small, err = New(SetHashFunc(SipHash), SetNumBuckets(64),
+		SetFingerprintBits(16), SetNumSlots(1), SetRebuild(true))
+	if err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+	err = small.Merge(gt)
+	if err != nil {
+		t.Fatalf("could not merge into rebuilding table: %v", err)
+	}
+	checkItems(t, small, items, 0, 150)
+}
+
+func TestIntersectDifference(t *testing.T) {
+
+	// only the items in both tables must remain
+	gt, other, items := combineTables(t)
+	err := gt.Intersect(other)
+	if err != nil {
+		t.Fatalf("could not intersect tables: %v", err)
+	}
+	checkItems(t, gt, items, 50, 100)
+	checkCount(t, gt, 50)
+
+	// only the items not in the other table must remain
+	gt, other, items = combineTables(t)
+	err = gt.Difference(other)
+	if err != nil {
+		t.Fatalf("could not subtract tables: %v", err)
+	}
+	checkItems(t, gt, items, 0, 50)
+	checkCount(t, gt, 50)
+}
+
+func TestCombineCounting(t *testing.T) {
+
+	// copies are added up by merging and matched one by one otherwise
+	item := benchItems(0, 1)[0]
+	for _, test := range []struct {
+		op       func(gt *GokooTable, other *GokooTable) error
+		expected int
+	}{
+		{(*GokooTable).Merge, 8},
+		{(*GokooTable).Intersect, 3},
+		{(*GokooTable).Difference, 2},
+	} {
+		tables := make([]*GokooTable, 2)
+		for k, copies := range []int{5, 3} {
+			gt, err := New(SetHashFunc(SipHash), SetNumBuckets(64),
+				SetFingerprintBits(16), SetCounting(true))
+			if err != nil {
+				t.Fatalf("could not create table: %v", err)
+			}
+			for n := 0; n < copies; n++ {
+				gt.Insert(item)
+			}
+			tables[k] = gt
+		}
+
+		err := test.op(tables[0], tables[1])
+		if err != nil {
+			t.Fatalf("could not combine tables: %v", err)
+		}
+		if tables[0].CountItem(item) != test.expected {
+			t.Errorf("wrong number of copies: %v != %v",
+				tables[0].CountItem(item), test.expected)
+		}
+		checkCount(t, tables[0], test.expected)
+	}
+}
+
+func TestCombineIncompatible(t *testing.T) {
+
+	gt, err := New(SetHashFunc(SipHash), SetNumBuckets(64))
+	if err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+	if gt.Merge(gt) == nil {
+		t.Errorf("combining table with itself did not fail")
+	}
+
+	// every difference in parameters or hash functions must be rejected
+	for _, options := range [][]func(*GokooTable){
+		{SetHashFunc(SipHash), SetNumBuckets(128)},
+		{SetHashFunc(SipHash), SetNumBuckets(64), SetFingerprintBits(12)},
+		{SetHashFunc(Sha256Hash), SetNumBuckets(64)},
+		{SetHashFunc(NewSipHash(1, 2)), SetNumBuckets(64)},
+		{SetSipHashKeys(1, 2), SetNumBuckets(64)},
+	} {
+		other, err := New(options...)
+		if err != nil {
+			t.Fatalf("could not create table: %v", err)
+		}
+		if gt.Merge(other) == nil || gt.Intersect(other) == nil ||
+			gt.Difference(other) == nil {
+			t.Errorf("combining incompatible tables did not fail")
+		}
+	}
+
+	// closures over different keys share their code, so custom hash functions
+	// can't be told apart
+	custom, err := New(SetHashFunc(NewSipHash(1, 2)), SetNumBuckets(64))
+	if err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+	other, err := New(SetHashFunc(NewSipHash(3, 4)), SetNumBuckets(64))
+	if err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+	if custom.Merge(other) == nil || custom.Intersect(other) == nil ||
+		custom.Difference(other) == nil {
+		t.Errorf("combining tables with different custom hashes did not fail")
+	}
+
+	// keyed tables need the same keys
+	keyed, err := New(SetSipHashKeys(1, 2), SetNumBuckets(64))
+	if err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+	other, err = New(SetSipHashKeys(1, 2), SetNumBuckets(64))
+	if err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+	if keyed.Merge(other) != nil {
+		t.Errorf("could not merge tables with the same keys")
+	}
+
+	// different slots are fine
+	other, err = New(SetHashFunc(SipHash), SetNumBuckets(64), SetNumSlots(8))
+	if err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+	if gt.Merge(other) != nil {
+		t.Errorf("could not merge tables with different slots")
+	}
+}
@@ -0,0 +1,436 @@
+package gokoo
+
+import (
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentTable is a cuckoo table that is safe for concurrent use. Lookups
+// run in parallel, and inserts and removes only lock the stripes covering the
+// two buckets of an item. Inserts that have to evict fingerprints first search
+// for a path of evictions to a free slot, and then lock only the two buckets
+// of each move along it. Only inserts that find no such path, and operations
+// on the stash, take an exclusive lock on the whole table. With atomic
+// buckets, lookups don't take any locks unless the stash is in use.
+type ConcurrentTable struct {
+	ptr    atomic.Pointer[GokooTable]
+	table  sync.RWMutex
+	locks  []sync.RWMutex
+	nGroup int
+}
+
+// NewConcurrent will create a new cuckoo filter that is safe for concurrent
+// use, with the same options as New.
+func NewConcurrent(options ...func(*GokooTable)) (*ConcurrentTable, error) {
+
+	gt, err := New(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	ct := &ConcurrentTable{
+		locks: make([]sync.RWMutex, 1024),
+	}
+	ct.ptr.Store(gt)
+	ct.regroup()
+
+	return ct, nil
+}
+
+// regroup will compute how many neighbouring buckets have to share a lock.
+// Fingerprints are bit-packed, so a bucket may share a byte with the next one,
+// and only groups of buckets that end on a byte boundary can be locked alone.
+func (ct *ConcurrentTable) regroup() {
+
+	// atomic buckets always consist of whole words
+	gt := ct.ptr.Load()
+	ct.nGroup = 1
+	if gt.lockFree {
+		return
+	}
+
+	bits := gt.nSlots * gt.sBits
+	for ct.nGroup*bits%8 != 0 {
+		ct.nGroup++
+	}
+}
+
+// stripe will return the index of the lock for bucket i.
+func (ct *ConcurrentTable) stripe(i int) int {
+	return i / ct.nGroup % len(ct.locks)
+}
+
+// lock will lock the stripes of both buckets, always in the same order so
+// that we can't deadlock.
+func (ct *ConcurrentTable) lock(i1 int, i2 int, write bool) (int, int) {
+
+	// order the stripes
+	s1, s2 := ct.stripe(i1), ct.stripe(i2)
+	if s1 > s2 {
+		s1, s2 = s2, s1
+	}
+
+	// lock the first stripe, and the second if it is a different one
+	if write {
+		ct.locks[s1].Lock()
+	} else {
+		ct.locks[s1].RLock()
+	}
+	if s2 != s1 {
+		if write {
+			ct.locks[s2].Lock()
+		} else {
+			ct.locks[s2].RLock()
+		}
+	}
+
+	return s1, s2
+}
+
+// unlock will unlock the stripes locked by lock.
+func (ct *ConcurrentTable) unlock(s1 int, s2 int, write bool) {
+
+	if s2 != s1 {
+		if write {
+			ct.locks[s2].Unlock()
+		} else {
+			ct.locks[s2].RUnlock()
+		}
+	}
+	if write {
+		ct.locks[s1].Unlock()
+	} else {
+		ct.locks[s1].RUnlock()
+	}
+}
+
+// Insert will try to add an item to the cuckoo table.
+func (ct *ConcurrentTable) Insert(item GokooItem) bool {
+
+	return ct.insert(&hashed{data: item.Bytes()})
+}
+
+// insert will try to add the hashed item to the cuckoo table.
+func (ct *ConcurrentTable) insert(h *hashed) bool {
+
+	// try to place the item while only locking the buckets we touch
+	ct.table.RLock()
+	gt := ct.ptr.Load()
+	f, i1, i2 := gt.locate(h)
+	ok := ct.place(gt, f, i1, i2)
+	ct.table.RUnlock()
+	if ok {
+		return true
+	}
+
+	// there was no path to a free slot, so the item may have to go into the
+	// stash, and the table may be rebuilt
+	ct.table.Lock()
+	defer ct.table.Unlock()
+	ok = ct.ptr.Load().insert(h)
+	ct.regroup()
+
+	return ok
+}
+
+// pathTries is the number of times an insert searches for a path of evictions
+// again, after other writers changed buckets along the previous one.
+const pathTries = 4
+
+// place will try to count fingerprint f, or add it to one of its buckets, and
+// move other fingerprints out of the way if they are full. Copies in the stash
+// are only counted on the slow path.
+func (ct *ConcurrentTable) place(gt *GokooTable, f uint32, i1 int,
+	i2 int) bool {
+
+	s1, s2 := ct.lock(i1, i2, true)
+	ok := gt.bump(i1, i2, f) || gt.add(i1, f) || gt.add(i2, f)
+	ct.unlock(s1, s2, true)
+	if ok {
+		return true
+	}
+
+	// both buckets were full, so we free a slot in one of them along a path
+	// of evictions, which other writers may get in the way of
+	atomic.AddInt64(&gt.nChains, 1)
+	for n := 0; n < pathTries; n++ {
+		path := ct.path(gt, i1, i2)
+		if path == nil {
+			return false
+		}
+		if !ct.shift(gt, path) {
+			continue
+		}
+		s1, s2 := ct.lock(i1, i2, true)
+		ok := gt.bump(i1, i2, f) || gt.add(i1, f) || gt.add(i2, f)
+		ct.unlock(s1, s2, true)
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// node is a bucket on a path of evictions, along with the slot of the bucket
+// before it on the path, whose fingerprint would move into it.
+type node struct {
+	bucket int
+	parent int
+	slot   int
+	v      uint32
+}
+
+// path will search breadth first for the shortest path of evictions from one
+// of the two buckets to a bucket with a free slot, and return its buckets. It
+// only locks one bucket at a time, so the path may be outdated once found, and
+// it looks at no more buckets than an insert would try evictions.
+func (ct *ConcurrentTable) path(gt *GokooTable, i1 int, i2 int) []node {
+
+	nodes := []node{{bucket: i1, parent: -1}, {bucket: i2, parent: -1}}
+	values := make([]uint32, gt.nSlots)
+	for k := 0; k < len(nodes) && k < gt.nTries; k++ {
+
+		// read the bucket while it is locked
+		i := nodes[k].bucket
+		s := ct.stripe(i)
+		ct.locks[s].RLock()
+		b := gt.bucket(i)
+		free := b.free() >= 0
+		for n := range values {
+			values[n] = b.get(n)
+		}
+		ct.locks[s].RUnlock()
+
+		// follow the parents back to the start of the path
+		if free {
+			var path []node
+			for ; k >= 0; k = nodes[k].parent {
+				path = append(path, nodes[k])
+			}
+			slices.Reverse(path)
+			return path
+		}
+
+		// otherwise, every fingerprint could move to its other bucket
+		for n, v := range values {
+			j := gt.secondaryIndex(i, v)
+			if j != i {
+				nodes = append(nodes,
+					node{bucket: j, parent: k, slot: n, v: v})
+			}
+		}
+	}
+
+	return nil
+}
+
+// shift will move the fingerprints along the path, starting from its end, so
+// that its first bucket has a free slot. It only locks the two buckets of each
+// move, and fails if another writer changed one of them since we found the
+// path; the fingerprints moved so far stay valid in their other buckets.
+func (ct *ConcurrentTable) shift(gt *GokooTable, path []node) bool {
+
+	for k := len(path) - 1; k > 0; k-- {
+		from, to := path[k-1].bucket, path[k].bucket
+		s1, s2 := ct.lock(from, to, true)
+		ok := gt.move(from, path[k].slot, path[k].v, to)
+		ct.unlock(s1, s2, true)
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// move will move value v from slot n of bucket i to a free slot of bucket j,
+// if it is still there and bucket j still has room.
+func (gt *GokooTable) move(i int, n int, v uint32, j int) bool {
+
+	b, d := gt.bucket(i), gt.bucket(j)
+	m := d.free()
+	if b.get(n) != v || m < 0 {
+		return false
+	}
+
+	// lock-free readers have to look again while the value is moving, and
+	// we keep track of the bucket fill levels
+	b.begin()
+	d.begin()
+	k := d.load()
+	atomic.AddInt64(&gt.fill[k], -1)
+	atomic.AddInt64(&gt.fill[k+1], 1)
+	d.set(m, v)
+	l := b.load()
+	atomic.AddInt64(&gt.fill[l], -1)
+	atomic.AddInt64(&gt.fill[l-1], 1)
+	b.clear(n)
+	b.end()
+	d.end()
+	atomic.AddInt64(&gt.nKicks, 1)
+
+	return true
+}
+
+// Lookup will check if the cuckoo table contains the given item.
+func (ct *ConcurrentTable) Lookup(item GokooItem) bool {
+
+	return ct.lookup(&hashed{data: item.Bytes()})
+}
+
+// lookup will check if the cuckoo table contains the hashed item.
+func (ct *ConcurrentTable) lookup(h *hashed) bool {
+
+	// atomic buckets can be read without locks
+	gt := ct.ptr.Load()
+	if gt.lockFree {
+		f, i1, i2 := gt.locate(h)
+		if gt.hasPair(i1, i2, f) {
+			return true
+		}
+		if atomic.LoadInt64(&gt.nStashed) == 0 {
+			return false
+		}
+	}
+
+	ct.table.RLock()
+	defer ct.table.RUnlock()
+
+	// check both buckets while they are locked for reading
+	gt = ct.ptr.Load()
+	f, i1, i2 := gt.locate(h)
+	s1, s2 := ct.lock(i1, i2, false)
+	ok := gt.has(i1, f) || gt.has(i2, f)
+	ct.unlock(s1, s2, false)
+	if ok {
+		return true
+	}
+
+	// the stash is only modified under the exclusive lock
+	return gt.stashed(i1, i2, f) >= 0
+}
+
+// Remove will remove the item from the cuckoo table.
+func (ct *ConcurrentTable) Remove(item GokooItem) bool {
+
+	return ct.remove(&hashed{data: item.Bytes()})
+}
+
+// remove will remove the hashed item from the cuckoo table.
+func (ct *ConcurrentTable) remove(h *hashed) bool {
+
+	// try to delete the item from its buckets while only locking them
+	ct.table.RLock()
+	gt := ct.ptr.Load()
+	f, i1, i2 := gt.locate(h)
+	s1, s2 := ct.lock(i1, i2, true)
+	ok := gt.del(i1, f) || gt.del(i2, f)
+	ct.unlock(s1, s2, true)
+	drain := ok && len(gt.stash) > 0
+	ct.table.RUnlock()
+	if ok && !drain {
+		return true
+	}
+
+	// the stash can only be modified exclusively
+	ct.table.Lock()
+	defer ct.table.Unlock()
+	gt = ct.ptr.Load()
+	if drain {
+		gt.drain()
+		return true
+	}
+
+	return gt.remove(h)
+}
+
+// CountItem will return how often the item was inserted into the cuckoo table,
+// with the same limitations as for a single-threaded table.
+func (ct *ConcurrentTable) CountItem(item GokooItem) int {
+	return ct.countItem(&hashed{data: item.Bytes()})
+}
+
+// countItem will return how often the hashed item was inserted into the
+// cuckoo table.
+func (ct *ConcurrentTable) countItem(h *hashed) int {
+
+	ct.table.RLock()
+	defer ct.table.RUnlock()
+
+	// count the copies in both buckets while they are locked for reading
+	gt := ct.ptr.Load()
+	f, i1, i2 := gt.locate(h)
+	s1, s2 := ct.lock(i1, i2, false)
+	count := gt.countPair(i1, i2, f)
+	ct.unlock(s1, s2, false)
+
+	return count + gt.countStash(i1, i2, f)
+}
+
+// Count will return the number of items stored in the cuckoo table.
+func (ct *ConcurrentTable) Count() int {
+
+	ct.table.RLock()
+	defer ct.table.RUnlock()
+
+	return ct.ptr.Load().Count()
+}
+
+// Stats will return statistics about the cuckoo table.
+func (ct *ConcurrentTable) Stats() Stats {
+
+	ct.table.RLock()
+	defer ct.table.RUnlock()
+
+	return ct.ptr.Load().Stats()
+}
+
+// WriteTo will write a snapshot of the cuckoo table to the writer.
+func (ct *ConcurrentTable) WriteTo(w io.Writer) (int64, error) {
+
+	ct.table.Lock()
+	defer ct.table.Unlock()
+
+	return ct.ptr.Load().WriteTo(w)
+}
+
+// ReadFrom will replace the cuckoo table with the one read from the reader.
+func (ct *ConcurrentTable) ReadFrom(r io.Reader) (int64, error) {
+
+	ct.table.Lock()
+	defer ct.table.Unlock()
+
+	gt, n, err := ct.read(r)
+	if err != nil {
+		return n, err
+	}
+	ct.swap(gt)
+
+	return n, nil
+}
+
+// read will read a new table for the concurrent table from the reader, with
+// its hash function and random source, while the table has to be locked.
+func (ct *ConcurrentTable) read(r io.Reader) (*GokooTable, int64, error) {
+
+	// read into a new table, so lock-free readers keep using the old one
+	// until we switch over
+	cur := ct.ptr.Load()
+	gt := &GokooTable{hash: cur.hash, hash64: cur.hash64, rnd: cur.rnd}
+	n, err := gt.ReadFrom(r)
+	if err != nil {
+		return nil, n, err
+	}
+
+	return gt, n, nil
+}
+
+// swap will switch over to the new table, while the table has to be locked.
+func (ct *ConcurrentTable) swap(gt *GokooTable) {
+
+	ct.ptr.Store(gt)
+	ct.regroup()
+}
@@ -0,0 +1,180 @@
+package gokoo
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentGroup(t *testing.T) {
+
+	// buckets that end on a byte boundary get their own lock
+	ct, _ := NewConcurrent(SetNumSlots(4), SetFingerprintBits(8))
+	if ct.nGroup != 1 {
+		t.Errorf("wrong group size for aligned buckets: %v", ct.nGroup)
+	}
+
+	// otherwise, buckets are grouped until they do
+	ct, _ = NewConcurrent(SetNumSlots(3), SetFingerprintBits(7))
+	if ct.nGroup != 8 {
+		t.Errorf("wrong group size for unaligned buckets: %v", ct.nGroup)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+
+	// create a small table that has to grow, with unaligned buckets
+	ct, _ := NewConcurrent(
+		SetNumBuckets(64),
+		SetNumSlots(3),
+		SetFingerprintBits(12),
+		SetStashSize(4),
+		SetRebuild(true),
+	)
+
+	// create a set of items for each goroutine
+	workers := 16
+	count := 200
+	sets := make([][]*bytes.Buffer, workers)
+	for w := range sets {
+		sets[w] = randomItems(t, count)
+	}
+
+	// run the given function for each item set in parallel
+	hammer := func(op func(item *bytes.Buffer) bool, msg string) {
+		var wg sync.WaitGroup
+		for _, items := range sets {
+			wg.Add(1)
+			go func(items []*bytes.Buffer) {
+				defer wg.Done()
+				for _, item := range items {
+					if !op(item) {
+						t.Errorf("%v error", msg)
+					}
+				}
+			}(items)
+		}
+		wg.Wait()
+	}
+
+	// insert and lookup all items from all goroutines
+	hammer(func(item *bytes.Buffer) bool {
+		return ct.Insert(item) && ct.Lookup(item)
+	}, "insert")
+	if ct.Count() != workers*count {
+		t.Errorf("wrong count after insert: %v", ct.Count())
+	}
+
+	// lookup all items in parallel once everything is inserted
+	hammer(func(item *bytes.Buffer) bool {
+		return ct.Lookup(item)
+	}, "lookup")
+
+	// remove all items from all goroutines
+	hammer(func(item *bytes.Buffer) bool {
+		return ct.Remove(item)
+	}, "delete")
+	if ct.Count() != 0 {
+		t.Errorf("wrong count after delete: %v", ct.Count())
+	}
+}
+
+func TestConcurrentEvictions(t *testing.T) {
+
+	// fill a table so high that many inserts have to evict fingerprints,
+	// while the table is held like lookups do, so that inserts which take
+	// the exclusive lock would have to wait
+	ct, _ := NewConcurrent(SetNumBuckets(1024), SetFingerprintBits(16))
+	items := benchItems(0, 3800)
+	ct.table.RLock()
+	done := make(chan int)
+	go func() {
+		failed := 0
+		for _, item := range items {
+			if !ct.Insert(item) {
+				failed++
+			}
+		}
+		done <- failed
+	}()
+	select {
+	case failed := <-done:
+		ct.table.RUnlock()
+		if failed != 0 {
+			t.Errorf("insert error: %v not inserted", failed)
+		}
+	case <-time.After(5 * time.Second):
+		ct.table.RUnlock()
+		<-done
+		t.Fatalf("inserts with evictions locked the whole table")
+	}
+
+	if ct.Stats().AvgKicks == 0 {
+		t.Errorf("no fingerprints were moved")
+	}
+	for _, item := range items {
+		if !ct.Lookup(item) {
+			t.Fatalf("lookup error after evictions")
+		}
+	}
+}
+
+func TestConcurrentLockFree(t *testing.T) {
+
+	// create a table with atomic buckets that is filled quite high
+	ct, _ := NewConcurrent(
+		SetAtomicBuckets(true),
+		SetNumBuckets(64),
+		SetNumTries(64),
+		SetStashSize(4),
+	)
+	stable := randomItems(t, 160)
+	for _, item := range stable {
+		if !ct.Insert(item) {
+			t.Fatalf("insert error")
+		}
+	}
+
+	// keep inserting and removing other items, which moves the stable items
+	// around through evictions
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		churn := randomItems(t, 60)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, item := range churn {
+				ct.Insert(item)
+			}
+			for _, item := range churn {
+				ct.Remove(item)
+			}
+		}
+	}()
+
+	// meanwhile, the stable items must always be found without locking
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for n := 0; n < 50; n++ {
+				for _, item := range stable {
+					if !ct.Lookup(item) {
+						t.Errorf("lookup error during evictions")
+					}
+				}
+			}
+		}()
+	}
+	readers.Wait()
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,343 @@
+package gokoo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"reflect"
+)
+
+// magic is written at the start of every serialized table.
+var magic = [4]byte{'G', 'O', 'K', 'O'}
+
+// version is the current version of the binary format.
+const version byte = 1
+
+// identifiers of the hash function a serialized table was created with; tables
+// using any other function are stored as custom and can only be read into a
+// table that was created with a custom hash function as well
+const (
+	hashCustom byte = iota
+	hashDummy
+	hashSha256
+	hashSip
+	hashSipKeyed
+)
+
+// header is the fixed size part at the start of a serialized table.
+type header struct {
+	Magic    [4]byte
+	Version  byte
+	HashID   byte
+	Rebuild  bool
+	Atomic   bool
+	Counting bool
+	NBuckets uint64
+	NSlots   uint64
+	FBits    uint64
+	NTries   uint64
+	NGrowth  uint64
+	MaxFP    float64
+	NStash   uint64
+	NVictims uint64
+	K0       uint64
+	K1       uint64
+}
+
+// hashID will return the identifier for the given hash function. Bundled hash
+// functions are always used in their 64-bit version.
+func hashID(hash GokooHash64) byte {
+
+	// compare the function pointer against all bundled hash functions
+	pointer := reflect.ValueOf(hash).Pointer()
+	switch pointer {
+	case reflect.ValueOf(DummyHash64).Pointer():
+		return hashDummy
+	case reflect.ValueOf(Sha256Hash64).Pointer():
+		return hashSha256
+	case reflect.ValueOf(SipHash64).Pointer():
+		return hashSip
+	}
+
+	// any other function is custom
+	return hashCustom
+}
+
+// hashFunc will return both versions of the hash function for the header,
+// falling back to the current ones for custom hash functions.
+func (gt *GokooTable) hashFunc(h *header) (GokooHash, GokooHash64, error) {
+
+	switch h.HashID {
+	case hashDummy:
+		return DummyHash, DummyHash64, nil
+	case hashSha256:
+		return Sha256Hash, Sha256Hash64, nil
+	case hashSip:
+		return SipHash, SipHash64, nil
+	case hashSipKeyed:
+		return NewSipHash(h.K0, h.K1), NewSipHash64(h.K0, h.K1), nil
+	case hashCustom:
+		if gt.hash64 == nil || gt.keyed || hashID(gt.hash64) != hashCustom {
+			return nil, nil, errors.New("table was serialized with a custom" +
+				" hash function that has to be set before reading")
+		}
+		return gt.hash, gt.hash64, nil
+	}
+
+	return nil, nil, errors.New("unknown hash function identifier")
+}
+
+// countWriter keeps track of the number of bytes written.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// countReader keeps track of the number of bytes read.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// MarshalBinary will encode the cuckoo table into a byte slice.
+func (gt *GokooTable) MarshalBinary() ([]byte, error) {
+
+	buf := &bytes.Buffer{}
+	_, err := gt.WriteTo(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary will replace the cuckoo table with the one encoded in the
+// byte slice.
+func (gt *GokooTable) UnmarshalBinary(data []byte) error {
+
+	r := bytes.NewReader(data)
+	_, err := gt.ReadFrom(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Len() != 0 {
+		return errors.New("trailing data after serialized table")
+	}
+
+	return nil
+}
+
+// WriteTo will write the cuckoo table to the writer. The format consists of a
+// header with all parameters, followed by the buckets, the stash and a CRC-32
+// checksum of everything before it. The keys of a keyed hash function are part
+// of the header, so the output has to be kept as secret as the keys.
+func (gt *GokooTable) WriteTo(w io.Writer) (int64, error) {
+
+	// all data goes through the checksum on its way to the writer
+	cw := &countWriter{w: w}
+	crc := crc32.NewIEEE()
+	mw := io.MultiWriter(cw, crc)
+
+	// write the header with all parameters, including the keys of a keyed
+	// hash function
+	h := header{
+		Magic:    magic,
+		Version:  version,
+		HashID:   hashID(gt.hash64),
+		Rebuild:  gt.rebuild,
+		Atomic:   gt.lockFree,
+		Counting: gt.counting,
+		NBuckets: uint64(gt.nBuckets),
+		NSlots:   uint64(gt.nSlots),
+		FBits:    uint64(gt.fBits),
+		NTries:   uint64(gt.nTries),
+		NGrowth:  uint64(gt.nGrowth),
+		MaxFP:    gt.maxFP,
+		NStash:   uint64(gt.nStash),
+		NVictims: uint64(len(gt.stash)),
+	}
+	if gt.keyed {
+		h.HashID = hashSipKeyed
+		h.K0 = gt.k0
+		h.K1 = gt.k1
+	}
+	err := binary.Write(mw, binary.LittleEndian, &h)
+	if err != nil {
+		return cw.n, err
+	}
+
+	// write the bit-packed fingerprints
+	_, err = mw.Write(gt.packed())
+	if err != nil {
+		return cw.n, err
+	}
+
+	// write the bucket and fingerprint of each victim in the stash
+	for _, vic := range gt.stash {
+		err = binary.Write(mw, binary.LittleEndian, uint64(vic.bucket))
+		if err != nil {
+			return cw.n, err
+		}
+		err = binary.Write(mw, binary.LittleEndian, vic.f)
+		if err != nil {
+			return cw.n, err
+		}
+	}
+
+	// finish with the checksum, which is not part of itself
+	err = binary.Write(cw, binary.LittleEndian, crc.Sum32())
+	return cw.n, err
+}
+
+// ReadFrom will replace the cuckoo table with the one read from the reader.
+// The table is only modified if the whole table could be read successfully.
+func (gt *GokooTable) ReadFrom(r io.Reader) (int64, error) {
+
+	// all data goes through the checksum on its way from the reader
+	cr := &countReader{r: r}
+	crc := crc32.NewIEEE()
+	tr := io.TeeReader(cr, crc)
+
+	// read and check the header
+	var h header
+	err := binary.Read(tr, binary.LittleEndian, &h)
+	if err != nil {
+		return cr.n, err
+	}
+	if h.Magic != magic {
+		return cr.n, errors.New("data is not a serialized table")
+	}
+	if h.Version != version {
+		return cr.n, errors.New("unsupported serialization version")
+	}
+
+	// set up a new table with the parameters from the header
+	hash, hash64, err := gt.hashFunc(&h)
+	if err != nil {
+		return cr.n, err
+	}
+	tmp := &GokooTable{
+		rebuild:  h.Rebuild,
+		lockFree: h.Atomic,
+		counting: h.Counting,
+		hash:     hash,
+		hash64:   hash64,
+		rnd:      gt.rnd,
+		keyed:    h.HashID == hashSipKeyed,
+		k0:       h.K0,
+		k1:       h.K1,
+		nBuckets: int(h.NBuckets),
+		nSlots:   int(h.NSlots),
+		fBits:    int(h.FBits),
+		nTries:   int(h.NTries),
+		nGrowth:  int(h.NGrowth),
+		maxFP:    h.MaxFP,
+		nStash:   int(h.NStash),
+	}
+	err = tmp.configure()
+	if err != nil {
+		return cr.n, err
+	}
+	if h.NVictims > h.NStash {
+		return cr.n, errors.New("stash holds more victims than its size")
+	}
+
+	// read the bit-packed fingerprints
+	data, err := readFull(tr, packedLen(tmp.nBuckets*tmp.nSlots, tmp.sBits))
+	if err != nil {
+		return cr.n, err
+	}
+	tmp.unpack(data)
+
+	// read the victims in the stash one by one, as their number comes from
+	// the header as well
+	for v := uint64(0); v < h.NVictims; v++ {
+		var bucket uint64
+		err = binary.Read(tr, binary.LittleEndian, &bucket)
+		if err != nil {
+			return cr.n, err
+		}
+		if bucket >= h.NBuckets {
+			return cr.n, errors.New("stash refers to invalid bucket")
+		}
+		var f uint32
+		err = binary.Read(tr, binary.LittleEndian, &f)
+		if err != nil {
+			return cr.n, err
+		}
+		if tmp.fingerPrintOf(f) == 0 || f>>uint(tmp.sBits) != 0 {
+			return cr.n, errors.New("stash holds invalid fingerprint")
+		}
+		tmp.stash = append(tmp.stash, victim{bucket: int(bucket), f: f})
+	}
+
+	// read the checksum directly and compare
+	err = checkSum(cr, crc)
+	if err != nil {
+		return cr.n, err
+	}
+
+	// the statistics are not serialized, so we count what we have read
+	tmp.recount()
+
+	// everything was read successfully, so take over the new table
+	*gt = *tmp
+	return cr.n, nil
+}
+
+// readChunk is the amount of data we allocate before reading more of it.
+const readChunk = 1 << 16
+
+// readFull will read exactly size bytes from the reader. The buffer doubles as
+// the data arrives, so a stream that is shorter than its header claims fails
+// before we allocate all of the size.
+func readFull(r io.Reader, size int) ([]byte, error) {
+
+	data := make([]byte, 0, min(size, readChunk))
+	for len(data) < size {
+		if len(data) == cap(data) {
+			grown := make([]byte, len(data), min(size, 2*cap(data)))
+			copy(grown, data)
+			data = grown
+		}
+		n, err := io.ReadFull(r, data[len(data):cap(data)])
+		data = data[:len(data)+n]
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// checkSum will read a checksum from the reader and compare it to the one
+// that was calculated.
+func checkSum(r io.Reader, crc hash.Hash32) error {
+
+	var sum uint32
+	err := binary.Read(r, binary.LittleEndian, &sum)
+	if err != nil {
+		return err
+	}
+	if sum != crc.Sum32() {
+		return errors.New("checksum mismatch in serialized table")
+	}
+
+	return nil
+}
@@ -0,0 +1,294 @@
+package gokoo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalUnmarshal(t *testing.T) {
+
+	// create a table with non-default parameters and fill it
+	count := 100
+	items := randomItems(t, count)
+	gt, _ := New(
+		SetHashFunc(SipHash),
+		SetNumBuckets(16),
+		SetNumSlots(2),
+		SetNumBytes(2),
+		SetNumTries(16),
+		SetRebuild(true),
+	)
+	for _, item := range items {
+		gt.Insert(item)
+	}
+
+	// marshal and unmarshal into a default table
+	data, err := gt.MarshalBinary()
+	if err != nil {
+		t.Fatalf("could not marshal table: %v", err)
+	}
+	gt2, _ := New()
+	err = gt2.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf("could not unmarshal table: %v", err)
+	}
+
+	// check that the unmarshaled table is identical
+	if reflect.ValueOf(gt2.hash).Pointer() != reflect.ValueOf(SipHash).Pointer() {
+		t.Errorf("hash function not restored")
+	}
+	if gt2.nBuckets != gt.nBuckets || gt2.nSlots != gt.nSlots ||
+		gt2.fBits != gt.fBits || gt2.nTries != gt.nTries ||
+		gt2.rebuild != gt.rebuild || gt2.iBits != gt.iBits {
+		t.Errorf("parameters not restored")
+	}
+	if !bytes.Equal(gt2.buckets, gt.buckets) {
+		t.Errorf("buckets not restored")
+	}
+
+	// check that all items can still be found
+	lookupErr := 0
+	for _, item := range items {
+		if !gt2.Lookup(item) {
+			lookupErr++
+		}
+	}
+	if lookupErr != 0 {
+		t.Errorf("lookup error: %v false negatives", lookupErr)
+	}
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+
+	// create a table and fill it
+	gt, _ := New(SetNumBuckets(32))
+	for _, item := range randomItems(t, 50) {
+		gt.Insert(item)
+	}
+
+	// write the table to a buffer
+	buf := &bytes.Buffer{}
+	nWrite, err := gt.WriteTo(buf)
+	if err != nil {
+		t.Fatalf("could not write table: %v", err)
+	}
+	if nWrite != int64(buf.Len()) {
+		t.Errorf("wrong number of bytes written: %v != %v", nWrite, buf.Len())
+	}
+
+	// read the table back from the buffer
+	gt2, _ := New()
+	nRead, err := gt2.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("could not read table: %v", err)
+	}
+	if nRead != nWrite {
+		t.Errorf("wrong number of bytes read: %v != %v", nRead, nWrite)
+	}
+	if !bytes.Equal(gt2.buckets, gt.buckets) {
+		t.Errorf("buckets not restored")
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+
+	gt, _ := New()
+	for _, item := range randomItems(t, 10) {
+		gt.Insert(item)
+	}
+	data, _ := gt.MarshalBinary()
+
+	// corrupt a fingerprint and check that the checksum catches it
+	corrupt := make([]byte, len(data))
+	copy(corrupt, data)
+	corrupt[len(corrupt)-5] ^= 0xff
+	gt2, _ := New()
+	err := gt2.UnmarshalBinary(corrupt)
+	if err == nil {
+		t.Errorf("could unmarshal corrupted data")
+	}
+
+	// check that the failed unmarshal did not modify the table
+	if gt2.nBuckets != 8 || len(gt2.buckets) != 32 {
+		t.Errorf("table modified by failed unmarshal")
+	}
+
+	// check that truncated data is rejected
+	err = gt2.UnmarshalBinary(data[:len(data)-1])
+	if err == nil {
+		t.Errorf("could unmarshal truncated data")
+	}
+
+	// check that a custom hash function has to be provided
+	custom := func(input []byte) []byte { return Sha256Hash(input) }
+	gt3, _ := New(SetHashFunc(custom))
+	data, _ = gt3.MarshalBinary()
+	err = gt2.UnmarshalBinary(data)
+	if err == nil {
+		t.Errorf("could unmarshal custom hash without setting it")
+	}
+	gt4, _ := New(SetHashFunc(custom))
+	err = gt4.UnmarshalBinary(data)
+	if err != nil {
+		t.Errorf("could not unmarshal custom hash: %v", err)
+	}
+
+	// check that parameters the dimensions don't cover are validated as well,
+	// even with a valid checksum
+	gt5, _ := New(SetRebuild(true))
+	data, _ = gt5.MarshalBinary()
+	err = gt2.UnmarshalBinary(patchHeader(t, data, func(h *header) {}))
+	if err != nil {
+		t.Errorf("could not unmarshal unchanged header: %v", err)
+	}
+	for _, patch := range []func(h *header){
+		func(h *header) { h.NGrowth = 1 << 62 },
+		func(h *header) { h.NTries = 1 << 40 },
+	} {
+		patched := patchHeader(t, data, patch)
+		err = gt2.UnmarshalBinary(patched)
+		if err == nil {
+			t.Errorf("could unmarshal invalid header")
+		}
+	}
+}
+
+// patchHeader will change the header of the serialized table and fix up its
+// checksum.
+func patchHeader(t *testing.T, data []byte, patch func(h *header)) []byte {
+
+	var h header
+	r := bytes.NewReader(data)
+	err := binary.Read(r, binary.LittleEndian, &h)
+	if err != nil {
+		t.Fatalf("could not read header: %v", err)
+	}
+	patch(&h)
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, &h)
+	buf.Write(data[len(data)-r.Len() : len(data)-4])
+	binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+
+	return buf.Bytes()
+}
+
+func TestMarshalSipHashKeys(t *testing.T) {
+
+	// create a table with random keys and fill it
+	items := randomItems(t, 50)
+	gt, err := New(SetRandomSipHashKeys(), SetNumBuckets(64))
+	if err != nil {
+		t.Fatalf("could not construct with random keys: %v", err)
+	}
+	for _, item := range items {
+		gt.Insert(item)
+	}
+
+	// unmarshal into a default table, which has to pick up the keys
+	data, _ := gt.MarshalBinary()
+	gt2, _ := New()
+	err = gt2.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf("could not unmarshal keyed table: %v", err)
+	}
+	if !gt2.keyed || gt2.k0 != gt.k0 || gt2.k1 != gt.k1 {
+		t.Errorf("keys not restored")
+	}
+	for _, item := range items {
+		if !gt2.Lookup(item) {
+			t.Errorf("lookup error after unmarshal")
+		}
+	}
+}
+
+// headerData will encode a header with the given dimensions and stash, which
+// claims more data than follows it.
+func headerData(t testing.TB, nBuckets uint64, nSlots uint64, nStash uint64,
+	nVictims uint64) []byte {
+
+	h := header{
+		Magic:    magic,
+		Version:  version,
+		HashID:   hashSip,
+		NBuckets: nBuckets,
+		NSlots:   nSlots,
+		FBits:    8,
+		NTries:   16,
+		NGrowth:  2,
+		NStash:   nStash,
+		NVictims: nVictims,
+	}
+	buf := &bytes.Buffer{}
+	err := binary.Write(buf, binary.LittleEndian, &h)
+	if err != nil {
+		t.Fatalf("could not write header: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func FuzzUnmarshalBinary(f *testing.F) {
+
+	// seed with valid tables of different kinds, and headers that claim huge
+	// buckets or stashes
+	for _, options := range [][]func(*GokooTable){
+		{SetHashFunc(SipHash), SetNumBuckets(16), SetStashSize(2)},
+		{SetHashFunc(SipHash), SetNumBuckets(7), SetFingerprintBits(12),
+			SetRebuild(true)},
+		{SetSipHashKeys(1, 2), SetAtomicBuckets(true)},
+		{SetHashFunc(SipHash), SetCounting(true)},
+	} {
+		gt, err := New(options...)
+		if err != nil {
+			f.Fatalf("could not create table: %v", err)
+		}
+		for _, item := range benchItems(0, 40) {
+			gt.Insert(item)
+		}
+		data, err := gt.MarshalBinary()
+		if err != nil {
+			f.Fatalf("could not marshal table: %v", err)
+		}
+		f.Add(data)
+	}
+	f.Add(headerData(f, 1<<30, 1<<30, 0, 0))
+	f.Add(headerData(f, 1<<20, 1<<10, 0, 0))
+	f.Add(append(headerData(f, 8, 4, 1<<62, 1<<40), make([]byte, 32)...))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+
+		gt, _ := New(SetHashFunc(SipHash))
+		err := gt.UnmarshalBinary(data)
+		if err != nil {
+			return
+		}
+
+		// a table that could be read must be usable, and encode the same way
+		// after another round trip
+		for _, item := range benchItems(0, 8) {
+			gt.Lookup(item)
+			gt.Remove(item)
+		}
+		gt.Count()
+		data, err = gt.MarshalBinary()
+		if err != nil {
+			t.Fatalf("could not marshal table: %v", err)
+		}
+		gt2, _ := New(SetHashFunc(SipHash))
+		err = gt2.UnmarshalBinary(data)
+		if err != nil {
+			t.Fatalf("could not unmarshal table again: %v", err)
+		}
+		again, err := gt2.MarshalBinary()
+		if err != nil {
+			t.Fatalf("could not marshal table again: %v", err)
+		}
+		if !bytes.Equal(again, data) {
+			t.Errorf("round trip changed the table")
+		}
+	})
+}
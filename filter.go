@@ -0,0 +1,88 @@
+package gokoo
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// Filter is a cuckoo filter for values of a given type, which are encoded into
+// bytes with an encoder instead of implementing GokooItem. Like the table it
+// wraps, a filter is not safe for concurrent use.
+type Filter[T any] struct {
+	gt     *GokooTable
+	encode func(T, []byte) []byte
+	buf    []byte
+}
+
+// NewFilter will create a new cuckoo filter for values that are encoded with
+// the given function, which has to append the encoding of a value to the
+// buffer and return it. The options are the same as for New.
+func NewFilter[T any](encode func(T, []byte) []byte,
+	options ...func(*GokooTable)) (*Filter[T], error) {
+
+	gt, err := New(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filter[T]{gt: gt, encode: encode}, nil
+}
+
+// item will encode the value into the reusable buffer and return it as item.
+func (f *Filter[T]) item(value T) *hashed {
+	f.buf = f.encode(value, f.buf[:0])
+	return &hashed{data: f.buf}
+}
+
+// Insert will try to add the value to the cuckoo filter.
+func (f *Filter[T]) Insert(value T) bool {
+	return f.gt.insert(f.item(value))
+}
+
+// Lookup will check if the cuckoo filter contains the value.
+func (f *Filter[T]) Lookup(value T) bool {
+	return f.gt.lookup(f.item(value))
+}
+
+// Remove will remove the value from the cuckoo filter.
+func (f *Filter[T]) Remove(value T) bool {
+	return f.gt.remove(f.item(value))
+}
+
+// Table will return the cuckoo table behind the filter, which gives access to
+// its statistics and serialization.
+func (f *Filter[T]) Table() *GokooTable {
+	return f.gt
+}
+
+// Integer is any integer type that can be encoded with EncodeInteger.
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// EncodeString will append the bytes of the string to the buffer.
+func EncodeString(value string, buf []byte) []byte {
+	return append(buf, value...)
+}
+
+// EncodeInteger will append the integer as eight bytes in little-endian order
+// to the buffer, which is the same encoding InsertUint64 uses, so integers of
+// all types with the same value are the same item.
+func EncodeInteger[T Integer](value T, buf []byte) []byte {
+	return binary.LittleEndian.AppendUint64(buf, uint64(value))
+}
+
+// EncodeUUID will append the sixteen bytes of a UUID to the buffer.
+func EncodeUUID(value [16]byte, buf []byte) []byte {
+	return append(buf, value[:]...)
+}
+
+// EncodeAddr will append the binary encoding of the IP address to the buffer,
+// which is the same one BinaryItem uses.
+func EncodeAddr(value netip.Addr, buf []byte) []byte {
+
+	// encoding an address can't fail
+	buf, _ = value.AppendBinary(buf)
+	return buf
+}
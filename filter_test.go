@@ -0,0 +1,83 @@
+package gokoo
+
+import (
+	"net/netip"
+	"strconv"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+
+	// strings are the same items as for the table
+	count := 200
+	fs, err := NewFilter(EncodeString, SetHashFunc(SipHash), SetNumBuckets(128))
+	if err != nil {
+		t.Fatalf("could not create string filter: %v", err)
+	}
+	for k := 0; k < count; k++ {
+		if !fs.Insert(strconv.Itoa(k)) {
+			t.Errorf("insert error for string %v", k)
+		}
+	}
+	for k := 0; k < count; k++ {
+		if !fs.Lookup(strconv.Itoa(k)) || !fs.Table().LookupString(
+			strconv.Itoa(k)) {
+			t.Errorf("lookup error for string %v", k)
+		}
+	}
+	for k := 0; k < count; k++ {
+		if !fs.Remove(strconv.Itoa(k)) {
+			t.Errorf("delete error for string %v", k)
+		}
+	}
+	if fs.Table().Count() != 0 {
+		t.Errorf("wrong count after delete: %v", fs.Table().Count())
+	}
+
+	// integers of any type are the same items as for the table
+	fi, _ := NewFilter(EncodeInteger[int16], SetHashFunc(SipHash))
+	fi.Insert(-42)
+	if !fi.Table().LookupUint64(uint64(0xffffffffffffffd6)) {
+		t.Errorf("integer encoded differently from table")
+	}
+	if !fi.Lookup(-42) || !fi.Remove(-42) || fi.Lookup(-42) {
+		t.Errorf("wrong results for integer")
+	}
+
+	// UUIDs and addresses are stored with all their bytes
+	fu, _ := NewFilter(EncodeUUID)
+	uuid := [16]byte{15: 1}
+	fu.Insert(uuid)
+	if !fu.Lookup(uuid) || fu.Lookup([16]byte{0: 1}) {
+		t.Errorf("wrong results for UUID")
+	}
+	fa, _ := NewFilter(EncodeAddr)
+	addr := netip.MustParseAddr("2001:db8::1")
+	item, _ := BinaryItem(addr)
+	fa.Insert(addr)
+	if !fa.Lookup(addr) || !fa.Table().Lookup(item) {
+		t.Errorf("wrong results for address")
+	}
+
+	// invalid options are reported
+	_, err = NewFilter(EncodeString, SetNumSlots(0))
+	if err == nil {
+		t.Errorf("could create filter with invalid options")
+	}
+}
+
+func TestFilterAllocs(t *testing.T) {
+
+	// the buffer is reused, so only the first call may allocate
+	f, _ := NewFilter(EncodeString, SetHashFunc(SipHash))
+	s := "gokoo"
+	f.Insert(s)
+	allocs := testing.AllocsPerRun(100, func() {
+		f.Lookup(s)
+		f.Remove(s)
+		f.Insert(s)
+	})
+	if allocs != 0 {
+		t.Errorf("filter operations allocate: %v", allocs)
+	}
+}
@@ -0,0 +1,199 @@
+package gokoo
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// operations that can be encoded in a fuzzing sequence
+const (
+	opInsert = iota
+	opRemove
+	opLookup
+	opRoundTrip
+	numOps
+)
+
+// modelTable will create a table from the given fuzzing parameters, limiting
+// them to valid values.
+func modelTable(t *testing.T, seed int64, nBuckets uint8, nSlots uint8,
+	fBits uint8, flags uint8) *GokooTable {
+
+	// atomic buckets can't be rebuilt, so the flags choose one or the other,
+	// or counting mode
+	options := []func(*GokooTable){
+		SetHashFunc(SipHash),
+		SetNumBuckets(int(nBuckets)%64 + 1),
+		SetNumSlots(int(nSlots)%8 + 1),
+		SetFingerprintBits(int(fBits)%29 + 4),
+		SetNumTries(64),
+		SetStashSize(int(flags>>2) % 4),
+		SetRandSource(rand.NewSource(seed)),
+	}
+	switch flags % 4 {
+	case 1:
+		options = append(options, SetRebuild(true))
+	case 2:
+		options = append(options, SetAtomicBuckets(true))
+	case 3:
+		options = append(options, SetCounting(true), SetFingerprintBits(
+			int(fBits)%25+4))
+	}
+
+	gt, err := New(options...)
+	if err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+
+	return gt
+}
+
+// checkModel will run a sequence of operations on the table and compare it to
+// a multiset of the items. Every operation is encoded in two bytes, the first
+// choosing the operation and the second the item.
+func checkModel(t *testing.T, gt *GokooTable, ops []byte) {
+
+	items := benchItems(0, 256)
+	model := make(map[int]int)
+	count := 0
+	for k := 0; k+1 < len(ops); k += 2 {
+		id := int(ops[k+1])
+		item := items[id]
+		switch ops[k] % numOps {
+
+		// a failed insert must leave the table unchanged, which we compare
+		// in serialized form for tables that can fail
+		case opInsert:
+			var before []byte
+			if !gt.rebuild {
+				before, _ = gt.MarshalBinary()
+			}
+			if gt.Insert(item) {
+				model[id]++
+				count++
+				break
+			}
+			after, _ := gt.MarshalBinary()
+			if !bytes.Equal(before, after) {
+				t.Fatalf("failed insert changed the table after %v operations",
+					k/2)
+			}
+
+		// removing an inserted item must always succeed, but we can't remove
+		// other items as they might be false positives
+		case opRemove:
+			if model[id] == 0 {
+				continue
+			}
+			if !gt.Remove(item) {
+				t.Fatalf("could not remove item %v after %v operations", id, k/2)
+			}
+			model[id]--
+			count--
+
+		// inserted items must never be missed
+		case opLookup:
+			if model[id] > 0 && !gt.Lookup(item) {
+				t.Fatalf("missed item %v after %v operations", id, k/2)
+			}
+
+		// the table must survive serialization, and continue afterwards
+		case opRoundTrip:
+			data, err := gt.MarshalBinary()
+			if err != nil {
+				t.Fatalf("could not marshal after %v operations: %v", k/2, err)
+			}
+			gt2, _ := New(
+				SetHashFunc(SipHash),
+				SetRandSource(rand.NewSource(int64(k))),
+			)
+			err = gt2.UnmarshalBinary(data)
+			if err != nil {
+				t.Fatalf("could not unmarshal after %v operations: %v", k/2, err)
+			}
+			gt = gt2
+		}
+
+		if gt.Count() != count {
+			t.Fatalf("wrong count after %v operations: %v != %v",
+				k/2, gt.Count(), count)
+		}
+	}
+
+	// all remaining items must be found, and removed exactly as often as they
+	// were inserted
+	for id, n := range model {
+		if n > 0 && !gt.Lookup(items[id]) {
+			t.Fatalf("missed item %v at the end", id)
+		}
+		for ; n > 0; n-- {
+			if !gt.Remove(items[id]) {
+				t.Fatalf("could not remove item %v at the end", id)
+			}
+		}
+	}
+	if gt.Count() != 0 {
+		t.Fatalf("wrong count after removing everything: %v", gt.Count())
+	}
+}
+
+func FuzzInsertLookupRemove(f *testing.F) {
+
+	// seed with an empty sequence, and ones that fill the table and remove
+	// everything again
+	f.Add(int64(1), uint8(7), uint8(3), uint8(4), uint8(0), []byte{})
+	fill := make([]byte, 0, 512)
+	for n := 0; n < 128; n++ {
+		fill = append(fill, opInsert, byte(n))
+	}
+	for n := 0; n < 128; n++ {
+		fill = append(fill, opRemove, byte(n), opLookup, byte(n+1))
+	}
+	for flags := uint8(0); flags < 4; flags++ {
+		f.Add(int64(flags), uint8(15), uint8(3), uint8(8), flags|4<<2, fill)
+	}
+
+	f.Fuzz(func(t *testing.T, seed int64, nBuckets uint8, nSlots uint8,
+		fBits uint8, flags uint8, ops []byte) {
+
+		// keep single runs short
+		if len(ops) > 4096 {
+			ops = ops[:4096]
+		}
+
+		gt := modelTable(t, seed, nBuckets, nSlots, fBits, flags)
+		checkModel(t, gt, ops)
+	})
+}
+
+func TestModel(t *testing.T) {
+
+	// run random operation sequences on random configurations
+	rnd := rand.New(rand.NewSource(42))
+	for n := 0; n < 200; n++ {
+
+		// choose a small item space so items are inserted more than once,
+		// and fill the table before emptying it again
+		ops := make([]byte, 2*(rnd.Intn(1000)+1))
+		space := rnd.Intn(256) + 1
+		for k := 0; k < len(ops); k += 2 {
+			ops[k] = byte(rnd.Intn(numOps))
+			if k < len(ops)/2 && ops[k] == opRemove {
+				ops[k] = opInsert
+			}
+			ops[k+1] = byte(rnd.Intn(space))
+		}
+
+		seed, nBuckets, nSlots := rnd.Int63(), rnd.Intn(256), rnd.Intn(256)
+		fBits, flags := rnd.Intn(256), rnd.Intn(256)
+		name := fmt.Sprintf("%v/%v/%v/%v/%v", seed, nBuckets, nSlots, fBits,
+			flags)
+		t.Run(name, func(t *testing.T) {
+			gt := modelTable(t, seed, uint8(nBuckets), uint8(nSlots),
+				uint8(fBits), uint8(flags))
+			checkModel(t, gt, ops)
+		})
+	}
+}
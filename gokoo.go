@@ -1,34 +1,92 @@
 package gokoo
 
 import (
-	"bytes"
+	crand "crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"math"
+	"math/bits"
 	"math/rand"
+	"reflect"
+	"sync/atomic"
+	"time"
 
 	"github.com/dchest/siphash"
-	"github.com/vova616/xxhash"
 )
 
+// counterBits is the number of bits of the counter kept next to every
+// fingerprint in counting mode.
+const counterBits = 4
+
+// spareBits is the number of extra hash bits used for the primary index when
+// the number of buckets is not a power of two, which keeps the share of any
+// bucket within 1/256 of the others.
+const spareBits = 8
+
+// maxTries is the highest number of evictions an insert may try, which keeps
+// a single insert from running for too long.
+const maxTries = 1 << 16
+
 type GokooItem interface {
 	Bytes() []byte
 }
 
 type GokooHash func([]byte) []byte
 
+// GokooHash64 is a hash function returning a 64-bit integer, which the table
+// can use without allocating a byte slice for every hash.
+type GokooHash64 func([]byte) uint64
+
+// eviction records the fingerprint that was kicked out of a slot.
+type eviction struct {
+	bucket int
+	slot   int
+	f      uint32
+}
+
+// victim is a fingerprint that could not be placed, kept in the stash along
+// with one of its two buckets.
+type victim struct {
+	bucket int
+	f      uint32
+}
+
 type GokooTable struct {
 	rebuild  bool
+	lockFree bool
+	keyed    bool
+	randKeys bool
+	counting bool
+	k0       uint64
+	k1       uint64
 	nBuckets int
 	nSlots   int
-	nBytes   int
+	fBits    int
+	cBits    int
+	sBits    int
 	nTries   int
-	iBytes   int
-	occupied []bool
+	nGrowth  int
+	maxFP    float64
+	nStash   int
+	iBits    int
+	xBits    int
+	nWords   int
+	nPerWord int
 	buckets  []byte
+	words    []uint64
+	versions []uint64
+	stash    []victim
+	nStashed int64
+	nItems   int64
+	nDups    int64
+	nChains  int64
+	nKicks   int64
+	nFailed  int
+	fill     []int64
 	hash     GokooHash
-	buf      *bytes.Buffer
+	hash64   GokooHash64
+	rnd      *rand.Rand
 }
 
 // DummyHash is a wrapper for a dummy function that will always return 8 bytes
@@ -55,6 +113,65 @@ func SipHash(input []byte) []byte {
 	return output
 }
 
+// NewSipHash will return a SIP-2,4 hash function using the given keys. Keys
+// that are unknown to an attacker prevent them from precomputing items that
+// all end up in the same buckets.
+func NewSipHash(k0 uint64, k1 uint64) GokooHash {
+	return func(input []byte) []byte {
+		number := siphash.Hash(k0, k1, input)
+		output := make([]byte, 8)
+		binary.LittleEndian.PutUint64(output, number)
+		return output
+	}
+}
+
+// DummyHash64 is the 64-bit version of DummyHash.
+func DummyHash64(input []byte) uint64 {
+	var array [8]byte
+	copy(array[:], input)
+	return binary.LittleEndian.Uint64(array[:])
+}
+
+// Sha256Hash64 is the 64-bit version of Sha256Hash, returning the first eight
+// bytes of the SHA-256 hash.
+func Sha256Hash64(input []byte) uint64 {
+	array := sha256.Sum256(input)
+	return binary.LittleEndian.Uint64(array[:8])
+}
+
+// SipHash64 is the 64-bit version of SipHash.
+func SipHash64(input []byte) uint64 {
+	return siphash.Hash(0, 0, input)
+}
+
+// NewSipHash64 is the 64-bit version of NewSipHash.
+func NewSipHash64(k0 uint64, k1 uint64) GokooHash64 {
+	return func(input []byte) uint64 {
+		return siphash.Hash(k0, k1, input)
+	}
+}
+
+// native will return the 64-bit version of a hash function, which is either
+// one of the bundled ones or a wrapper that converts the byte slice.
+func native(hash GokooHash) GokooHash64 {
+
+	// compare the function pointer against all bundled hash functions
+	pointer := reflect.ValueOf(hash).Pointer()
+	switch pointer {
+	case reflect.ValueOf(DummyHash).Pointer():
+		return DummyHash64
+	case reflect.ValueOf(Sha256Hash).Pointer():
+		return Sha256Hash64
+	case reflect.ValueOf(SipHash).Pointer():
+		return SipHash64
+	}
+
+	// any other function has to be converted
+	return func(input []byte) uint64 {
+		return hashValue(hash(input))
+	}
+}
+
 // New will create a new cuckoo filter.
 func New(options ...func(*GokooTable)) (*GokooTable, error) {
 
@@ -63,28 +180,149 @@ func New(options ...func(*GokooTable)) (*GokooTable, error) {
 		hash:     Sha256Hash,
 		nBuckets: 8,
 		nSlots:   4,
-		nBytes:   1,
+		fBits:    8,
 		nTries:   512,
+		nGrowth:  2,
 	}
 
 	for _, option := range options {
 		option(gt)
 	}
 
-	gt.iBytes = int(math.Ceil(math.Sqrt(float64(gt.nBuckets))))
-	hashLen := len(gt.hash([]byte{}))
-	if hashLen < gt.iBytes+gt.nBytes {
-		return nil, errors.New("hash byte length insufficient for given" +
-			" number of buckets and fingerprint bytes")
+	// generate secret keys for the hash function if requested
+	if gt.randKeys {
+		var keys [16]byte
+		_, err := crand.Read(keys[:])
+		if err != nil {
+			return nil, err
+		}
+		k0 := binary.LittleEndian.Uint64(keys[:8])
+		k1 := binary.LittleEndian.Uint64(keys[8:])
+		SetSipHashKeys(k0, k1)(gt)
+	}
+
+	err := gt.configure()
+	if err != nil {
+		return nil, err
 	}
 
-	gt.occupied = make([]bool, gt.nBuckets*gt.nSlots)
-	gt.buckets = make([]byte, gt.nBuckets*gt.nSlots*gt.nBytes)
+	gt.allocate()
+	gt.fill = make([]int64, gt.nSlots+1)
+	gt.fill[0] = int64(gt.nBuckets)
 
 	return gt, nil
 }
 
-// SetRebuild will allow the table to automatically rebuild if it is full.
+// configure will derive the internal parameters from the options and check
+// that they are consistent.
+func (gt *GokooTable) configure() error {
+
+	if gt.nBuckets < 1 || gt.nSlots < 1 {
+		return errors.New("invalid table dimensions")
+	}
+
+	if gt.fBits < 4 || gt.fBits > 32 {
+		return errors.New("fingerprint bits must be between 4 and 32")
+	}
+
+	// we need enough bits to address all buckets, and the index and the
+	// fingerprint are both taken from the first 64 bits of the hash, so we
+	// always use hashes as 64-bit integers internally
+	gt.iBits = bits.Len(uint(gt.nBuckets - 1))
+	if gt.hash != nil && gt.hash64 == nil {
+		gt.hash64 = native(gt.hash)
+	}
+	if gt.hashBits() < gt.iBits+gt.fBits {
+		return errors.New("hash bit length insufficient for given" +
+			" number of buckets and fingerprint bits")
+	}
+
+	// other numbers of buckets than powers of two can't be covered evenly by
+	// the index bits, so we take as many spare bits from above the
+	// fingerprint as the hash has left
+	gt.xBits = 0
+	if gt.nBuckets&(gt.nBuckets-1) != 0 {
+		gt.xBits = min(spareBits, gt.hashBits()-gt.iBits-gt.fBits)
+	}
+
+	if gt.nTries < 0 || gt.nTries > maxTries {
+		return errors.New("number of tries must be between 0 and 65536")
+	}
+
+	if gt.nGrowth < 2 {
+		return errors.New("growth factor must be at least two")
+	}
+
+	if gt.nStash < 0 {
+		return errors.New("stash size can not be negative")
+	}
+
+	// in counting mode, every slot holds a counter above the fingerprint
+	gt.cBits = 0
+	if gt.counting {
+		gt.cBits = counterBits
+	}
+	gt.sBits = gt.fBits + gt.cBits
+	if gt.sBits > 32 {
+		return errors.New("fingerprint bits must be at most 28 in counting" +
+			" mode")
+	}
+
+	// the dimensions may come from serialized data, so the size of the
+	// buckets in bits must not overflow
+	if gt.nSlots > (math.MaxInt-7)/gt.sBits/gt.nBuckets {
+		return errors.New("table dimensions too large")
+	}
+
+	// a rebuild multiplies the slots by the growth factor, which must not
+	// overflow the dimensions either
+	if !gt.growable(gt.nSlots) {
+		return errors.New("growth factor too large for table dimensions")
+	}
+
+	// a rate of zero means that rebuilds are not limited
+	if !(gt.maxFP >= 0 && gt.maxFP <= 1) {
+		return errors.New("false positive rate must be between 0 and 1")
+	}
+
+	// atomic buckets are made of whole words, with slots not crossing words
+	if gt.lockFree && gt.rebuild {
+		return errors.New("atomic buckets can not be rebuilt")
+	}
+	gt.nPerWord = 64 / gt.sBits
+	gt.nWords = (gt.nSlots + gt.nPerWord - 1) / gt.nPerWord
+
+	// every table gets its own random source unless one was given
+	if gt.rnd == nil {
+		gt.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	return nil
+}
+
+// hashBits will return the number of bits we get from the hash function, of
+// which we use at most 64.
+func (gt *GokooTable) hashBits() int {
+
+	if gt.hash == nil {
+		return 64
+	}
+
+	hashBits := 8 * len(gt.hash([]byte{}))
+	if hashBits > 64 {
+		hashBits = 64
+	}
+
+	return hashBits
+}
+
+// SetRebuild will allow the table to automatically rebuild if it is full. As
+// only fingerprints are stored, a rebuild can not add buckets, and instead
+// multiplies the slots of every bucket by the growth factor. Each rebuild
+// therefore multiplies the false positive rate and the number of slots a
+// lookup compares by that factor as well, so a table that keeps rebuilding
+// should rather be recreated with a larger number of buckets. SetMaxFPRate
+// stops rebuilding before the false positive rate gets too high.
 func SetRebuild(rebuild bool) func(*GokooTable) {
 	return func(gt *GokooTable) {
 		gt.rebuild = rebuild
@@ -96,10 +334,48 @@ func SetRebuild(rebuild bool) func(*GokooTable) {
 func SetHashFunc(hash GokooHash) func(*GokooTable) {
 	return func(gt *GokooTable) {
 		gt.hash = hash
+		gt.hash64 = nil
+		gt.keyed = false
+		gt.randKeys = false
+	}
+}
+
+// SetHashFunc64 allows us to define a hash function returning a 64-bit integer
+// to be used with our cuckoo table.
+func SetHashFunc64(hash GokooHash64) func(*GokooTable) {
+	return func(gt *GokooTable) {
+		gt.hash = nil
+		gt.hash64 = hash
+		gt.keyed = false
+		gt.randKeys = false
+	}
+}
+
+// SetSipHashKeys will use SipHash with the given keys as hash function. Unlike
+// a function from NewSipHash set with SetHashFunc, the keys are remembered so
+// that a serialized table can be read again without setting them.
+func SetSipHashKeys(k0 uint64, k1 uint64) func(*GokooTable) {
+	return func(gt *GokooTable) {
+		gt.hash = NewSipHash(k0, k1)
+		gt.hash64 = NewSipHash64(k0, k1)
+		gt.keyed = true
+		gt.randKeys = false
+		gt.k0 = k0
+		gt.k1 = k1
+	}
+}
+
+// SetRandomSipHashKeys will use SipHash with secret keys from crypto/rand as
+// hash function, which are generated when the table is created.
+func SetRandomSipHashKeys() func(*GokooTable) {
+	return func(gt *GokooTable) {
+		gt.randKeys = true
 	}
 }
 
 // SetNumBuckets sets the number of buckets our cuckoo table initially uses.
+// Numbers that are not a power of two use a few more bits of the hash to
+// spread items evenly, as far as the hash has bits left after the fingerprint.
 func SetNumBuckets(nBuckets int) func(*GokooTable) {
 	return func(gt *GokooTable) {
 		gt.nBuckets = nBuckets
@@ -117,7 +393,15 @@ func SetNumSlots(nSlots int) func(*GokooTable) {
 // fingerprints.
 func SetNumBytes(nBytes int) func(*GokooTable) {
 	return func(gt *GokooTable) {
-		gt.nBytes = nBytes
+		gt.fBits = 8 * nBytes
+	}
+}
+
+// SetFingerprintBits sets the number of bits our cuckoo table uses for item
+// fingerprints, which allows sizes that are not a multiple of eight.
+func SetFingerprintBits(fBits int) func(*GokooTable) {
+	return func(gt *GokooTable) {
+		gt.fBits = fBits
 	}
 }
 
@@ -127,15 +411,90 @@ func SetNumTries(nTries int) func(*GokooTable) {
 	}
 }
 
+// SetAtomicBuckets will store each bucket in whole 64-bit words that are
+// accessed atomically, which allows lock-free lookups on concurrent tables.
+// Tables with atomic buckets can not rebuild.
+func SetAtomicBuckets(atomic bool) func(*GokooTable) {
+	return func(gt *GokooTable) {
+		gt.lockFree = atomic
+	}
+}
+
+// SetCounting will keep a small counter next to every fingerprint, so that
+// inserting an item that is already stored increments its counter instead of
+// taking another slot, and removing it decrements the counter. This takes four
+// more bits per slot, and fingerprints can only have up to 28 bits.
+func SetCounting(counting bool) func(*GokooTable) {
+	return func(gt *GokooTable) {
+		gt.counting = counting
+	}
+}
+
+// SetRandSource sets the source of the random decisions made during evictions,
+// so that a sequence of inserts can be reproduced exactly. The source is only
+// used by one table, and concurrent tables only use it under an exclusive lock.
+func SetRandSource(src rand.Source) func(*GokooTable) {
+	return func(gt *GokooTable) {
+		gt.rnd = rand.New(src)
+	}
+}
+
+// SetStashSize sets the number of fingerprints that can be kept in a victim
+// stash when they can not be placed in any bucket.
+func SetStashSize(nStash int) func(*GokooTable) {
+	return func(gt *GokooTable) {
+		gt.nStash = nStash
+	}
+}
+
+// SetGrowthFactor sets the factor by which the number of slots per bucket
+// grows when the table rebuilds, which is also the factor by which the false
+// positive rate and the cost of lookups grow.
+func SetGrowthFactor(nGrowth int) func(*GokooTable) {
+	return func(gt *GokooTable) {
+		gt.nGrowth = nGrowth
+	}
+}
+
+// SetMaxFPRate sets the highest false positive rate a table may reach through
+// rebuilds. A table only rebuilds if it stays below the rate when it is full
+// again, and inserts fail otherwise, which shows in the failed inserts of its
+// statistics. The default of zero doesn't limit rebuilds.
+func SetMaxFPRate(rate float64) func(*GokooTable) {
+	return func(gt *GokooTable) {
+		gt.maxFP = rate
+	}
+}
+
 // Insert will try to add an item to the cuckoo table.
 func (gt *GokooTable) Insert(item GokooItem) bool {
 
+	return gt.insert(&hashed{data: item.Bytes()})
+}
+
+// insert will try to add the hashed item to the cuckoo table.
+func (gt *GokooTable) insert(h *hashed) bool {
+
 	// get hash and fingerprint
-	hash := gt.hash(item.Bytes())
+	hash := h.of(gt)
 	f := gt.fingerPrint(hash)
 
-	// get first index and try to add to that bucket
-	i1 := gt.primaryIndex(hash)
+	return gt.insertAt(gt.primaryIndex(hash), f)
+}
+
+// insertAt will try to add fingerprint f to bucket i1 or its alternative.
+func (gt *GokooTable) insertAt(i1 int, f uint32) bool {
+
+	// in counting mode, an item that is already stored only has its counter
+	// incremented
+	if gt.counting {
+		i2 := gt.secondaryIndex(i1, f)
+		if gt.bump(i1, i2, f) || gt.bumpStash(i1, i2, f) {
+			return true
+		}
+	}
+
+	// try to add to the first bucket
 	if gt.add(i1, f) {
 		return true
 	}
@@ -147,15 +506,25 @@ func (gt *GokooTable) Insert(item GokooItem) bool {
 	}
 
 	// randomly pick i1 or i2 and keep evicting in that direction
-	if rand.Int()%2 == 1 {
+	if gt.rnd.Int()%2 == 1 {
 		i1 = i2
 	}
 
+	// keep track of all evictions so we can undo them if we fail, and let
+	// lock-free readers of the touched buckets know once we are done; the
+	// number of tries may come from serialized data, so the log only grows
+	// as far as the chain does
+	var undo []eviction
+	defer func() { gt.settle(undo) }()
+	atomic.AddInt64(&gt.nChains, 1)
+
 	// try for max tries number of time to kick back
 	for n := 0; n < gt.nTries; n++ {
 
 		// insert f into i1 and get the previous fingerprint
-		f = gt.evict(i1, f)
+		slot, fOld := gt.evict(i1, f)
+		undo = append(undo, eviction{bucket: i1, slot: slot, f: fOld})
+		f = fOld
 
 		// get the alternative index for ejected fingerprint and add it
 		i1 = gt.secondaryIndex(i1, f)
@@ -165,14 +534,46 @@ func (gt *GokooTable) Insert(item GokooItem) bool {
 	}
 
 	// at this point we did not manage to insert it without eviction for nTries
+	// so we keep the fingerprint that is still in flight in the stash if there
+	// is room; the item itself was already stored along the eviction chain
+	if len(gt.stash) < gt.nStash {
+		gt.setStash(append(gt.stash, victim{bucket: i1, f: f}))
+		return true
+	}
+
+	// if the stash is full as well, we stash the fingerprint that is still in
+	// flight anyway and grow the table, unless it would get too large; growing
+	// frees slots in every bucket and drains the stash into them, which may
+	// fill up the bucket of the fingerprint before it could be placed itself,
+	// but always places at least one victim, so the stash fits again
+	if gt.rebuild && gt.growable(gt.nSlots) && gt.accurate(gt.nSlots) {
+		gt.setStash(append(gt.stash, victim{bucket: i1, f: f}))
+		gt.grow()
+		return true
+	}
+
+	// otherwise, put back all evicted fingerprints in reverse order, which
+	// leaves the table exactly as it was before the insert
+	for n := len(undo) - 1; n >= 0; n-- {
+		e := undo[n]
+		gt.bucket(e.bucket).set(e.slot, e.f)
+	}
+
+	gt.nFailed++
 	return false
 }
 
 // Lookup will check if the cuckoo table contains the given item.
 func (gt *GokooTable) Lookup(item GokooItem) bool {
 
+	return gt.lookup(&hashed{data: item.Bytes()})
+}
+
+// lookup will check if the cuckoo table contains the hashed item.
+func (gt *GokooTable) lookup(h *hashed) bool {
+
 	// get the hash of the item bytes and the fingerprint
-	hash := gt.hash(item.Bytes())
+	hash := h.of(gt)
 	f := gt.fingerPrint(hash)
 
 	// get the first index and check if it contains the item
@@ -187,26 +588,62 @@ func (gt *GokooTable) Lookup(item GokooItem) bool {
 		return true
 	}
 
+	// check if the item is in the stash
+	if gt.stashed(i1, i2, f) >= 0 {
+		return true
+	}
+
 	// item wasn't found
 	return false
 }
 
+// CountItem will return how often the item was inserted into the cuckoo table.
+// This is only an estimate, as items with the same fingerprint and buckets
+// are counted together. Without counting mode, every copy takes a slot, so
+// only up to two full buckets of copies can be stored.
+func (gt *GokooTable) CountItem(item GokooItem) int {
+
+	f, i1, i2 := gt.locate(&hashed{data: item.Bytes()})
+	return gt.countPair(i1, i2, f) + gt.countStash(i1, i2, f)
+}
+
 // Delete will remove the item from the cuckoo table.
 func (gt *GokooTable) Remove(item GokooItem) bool {
 
+	return gt.remove(&hashed{data: item.Bytes()})
+}
+
+// remove will remove the hashed item from the cuckoo table.
+func (gt *GokooTable) remove(h *hashed) bool {
+
 	// get the hash of the item and the fingerprint
-	hash := gt.hash(item.Bytes())
+	hash := h.of(gt)
 	f := gt.fingerPrint(hash)
 
 	// get the first index and check if we can delete
 	i1 := gt.primaryIndex(hash)
 	if gt.del(i1, f) {
+		gt.drain()
 		return true
 	}
 
 	// get the second index and check if we can delete
 	i2 := gt.secondaryIndex(i1, f)
 	if gt.del(i2, f) {
+		gt.drain()
+		return true
+	}
+
+	// check if we can delete from the stash, where victims can hold several
+	// copies in counting mode as well
+	v := gt.stashed(i1, i2, f)
+	if v >= 0 && gt.copies(gt.stash[v].f) > 1 {
+		gt.stash[v].f -= 1 << uint(gt.fBits)
+		atomic.AddInt64(&gt.nDups, -1)
+		return true
+	}
+	if v >= 0 {
+		gt.setStash(append(gt.stash[:v], gt.stash[v+1:]...))
 		return true
 	}
 
@@ -214,140 +651,359 @@ func (gt *GokooTable) Remove(item GokooItem) bool {
 	return false
 }
 
+// locate will return the fingerprint and both bucket indexes for the hashed
+// item.
+func (gt *GokooTable) locate(h *hashed) (uint32, int, int) {
+
+	hash := h.of(gt)
+	f := gt.fingerPrint(hash)
+	i1 := gt.primaryIndex(hash)
+	i2 := gt.secondaryIndex(i1, f)
+
+	return f, i1, i2
+}
+
+// hashValue will return the first 64 bits of a hash as integer.
+func hashValue(hash []byte) uint64 {
+
+	// copy into a placeholder so shorter hashes are padded with zeroes
+	var array [8]byte
+	copy(array[:], hash)
+	return binary.LittleEndian.Uint64(array[:])
+}
+
 // fingerPrint will return the fingerprint for a given hash.
-func (gt *GokooTable) fingerPrint(hash []byte) []byte {
+func (gt *GokooTable) fingerPrint(hash uint64) uint32 {
+
+	// skip the bits used for the index and take the following bits
+	v := hash >> uint(gt.iBits)
+	f := uint32(v & (1<<uint(gt.fBits) - 1))
+
+	// zero marks an empty slot, so we remap it to a valid fingerprint
+	if f == 0 {
+		f = 1
+	}
 
-	// return the byte slice starting at right index and having right length
-	f := hash[gt.iBytes : gt.iBytes+gt.nBytes]
 	return f
 }
 
-// primaryIndex will return the primary index for a given hash.
-func (gt *GokooTable) primaryIndex(hash []byte) int {
+// fingerPrintOf will return the fingerprint stored in a slot, without the
+// counter used in counting mode.
+func (gt *GokooTable) fingerPrintOf(v uint32) uint32 {
+	return v & (1<<uint(gt.fBits) - 1)
+}
 
-	// create 4 byte slice to use with Uint32 and define range of bytes to get
-	slice := make([]byte, 4)
-	bytes := hash[0:gt.iBytes]
+// copies will return how many copies of its fingerprint a used slot holds,
+// which is always one unless we are in counting mode.
+func (gt *GokooTable) copies(v uint32) int {
+	return int(v>>uint(gt.fBits)) + 1
+}
 
-	// copy bytes into placeholder and put into integer
-	copy(slice, bytes)
-	i1 := int(binary.LittleEndian.Uint32(slice))
+// primaryIndex will return the primary index for a given hash.
+func (gt *GokooTable) primaryIndex(hash uint64) int {
+
+	// take the lowest bits of the hash that cover the number of buckets,
+	// which is all we need for a power of two
+	mask := uint64(1)<<uint(gt.iBits) - 1
+	i1 := hash & mask
+	if gt.nBuckets&(gt.nBuckets-1) == 0 {
+		return int(i1)
+	}
 
-	// return the index modulated for number of buckets
-	return i1 % gt.nBuckets
+	// otherwise, add the spare bits above the fingerprint and scale the wider
+	// value down to the number of buckets, which spreads it evenly instead of
+	// folding the top of the range onto the first buckets
+	spare := hash >> uint(gt.iBits+gt.fBits) & (1<<uint(gt.xBits) - 1)
+	x := i1 | spare<<uint(gt.iBits)
+	w := uint(gt.iBits + gt.xBits)
+	hi, lo := bits.Mul64(x, uint64(gt.nBuckets))
+	return int(hi<<(64-w) | lo>>w)
 }
 
-// secondaryIndex will return the secondary index of any given index.
-func (gt *GokooTable) secondaryIndex(i1 int, f []byte) int {
+// mix32 will spread the bits of a fingerprint over the whole integer, using
+// the finalizer of MurmurHash3, so similar fingerprints end up far apart.
+func mix32(f uint32) uint32 {
+	f ^= f >> 16
+	f *= 0x85ebca6b
+	f ^= f >> 13
+	f *= 0xc2b2ae35
+	f ^= f >> 16
+	return f
+}
 
-	// get the xxhash of the fingerprint
-	i2 := int(xxhash.Checksum32(f))
+// secondaryIndex will return the secondary index of any given index. Applying
+// it to the secondary index gives back the primary index for any number of
+// buckets, as (h - (h - i)) mod n = i.
+func (gt *GokooTable) secondaryIndex(i1 int, f uint32) int {
 
-	// XOR the primary index with the hash of the fingerprint
-	i2 = i1 ^ i2
+	// get the hash of the fingerprint modulated for number of buckets,
+	// ignoring the counter of fingerprints taken from a slot
+	h := int(uint64(mix32(gt.fingerPrintOf(f))) % uint64(gt.nBuckets))
+
+	// subtract the primary index from the hash of the fingerprint
+	i2 := h - i1
+	if i2 < 0 {
+		i2 += gt.nBuckets
+	}
 
 	// return the alternative index
-	return i2 % gt.nBuckets
+	return i2
 }
 
-// access will provide indexes for occupied and bucket to use for access.
-func (gt *GokooTable) access(i int, n int) (int, int, int) {
+// add will add an item to the given bucket, if possible.
+func (gt *GokooTable) add(i int, f uint32) bool {
+
+	// check if there is a free slot in this bucket
+	b := gt.bucket(i)
+	n := b.free()
+	if n < 0 {
+		return false
+	}
 
-	// index is the index in the occupied slice, begin and end the start and
-	// end indexes in the buckets slice for the fingerprint
-	index := i*gt.nSlots + n
-	begin := index * gt.nBytes
-	end := begin + gt.nBytes
+	// keep track of the bucket fill levels; these are shared between all
+	// buckets, so we update them atomically for concurrent tables
+	k := b.load()
+	atomic.AddInt64(&gt.fill[k], -1)
+	atomic.AddInt64(&gt.fill[k+1], 1)
+	atomic.AddInt64(&gt.nItems, 1)
 
-	// return all three indices
-	return index, begin, end
+	// save fingerprint and return
+	b.set(n, f)
+	return true
 }
 
-// add will add an item to the given bucket, if possible.
-func (gt *GokooTable) add(i int, f []byte) bool {
+// bump will increment the counter of fingerprint f in one of the two buckets,
+// if we are in counting mode and it has not reached its maximum yet.
+func (gt *GokooTable) bump(i1 int, i2 int, f uint32) bool {
 
-	// check all slots for this bucket
-	for n := 0; n < gt.nSlots; n++ {
+	if !gt.counting {
+		return false
+	}
+
+	// look for a copy of the fingerprint that can still be counted
+	for _, i := range [2]int{i1, i2} {
+		b := gt.bucket(i)
+		for n := 0; n < gt.nSlots; n++ {
+			v := b.get(n)
+			if gt.fingerPrintOf(v) != f || gt.copies(v) == 1<<counterBits {
+				continue
+			}
+			b.set(n, v+1<<uint(gt.fBits))
+			atomic.AddInt64(&gt.nDups, 1)
+			return true
+		}
+	}
 
-		// start index and stop index
-		o, b, e := gt.access(i, n)
+	return false
+}
 
-		// check if spot is free
-		if gt.occupied[o] {
-			continue
+// countPair will return the number of copies of fingerprint f in the two
+// buckets.
+func (gt *GokooTable) countPair(i1 int, i2 int, f uint32) int {
+
+	// both indexes can point to the same bucket
+	count := 0
+	for _, i := range [2]int{i1, i2} {
+		b := gt.bucket(i)
+		for n := 0; n < gt.nSlots; n++ {
+			v := b.get(n)
+			if gt.fingerPrintOf(v) == f {
+				count += gt.copies(v)
+			}
 		}
+		if i2 == i1 {
+			break
+		}
+	}
+
+	return count
+}
+
+// has will check if a given bucket contains fingerprint f.
+func (gt *GokooTable) has(i int, f uint32) bool {
+	return gt.bucket(i).find(f) >= 0
+}
+
+// del will delete an item from the given bucket, if possible.
+func (gt *GokooTable) del(i int, f uint32) bool {
+
+	// check if any slot of this bucket holds the fingerprint
+	b := gt.bucket(i)
+	n := b.find(f)
+	if n < 0 {
+		return false
+	}
 
-		// save fingerprint and return
-		gt.occupied[o] = true
-		copy(gt.buckets[b:e], f)
+	// in counting mode, only removing the last copy frees the slot
+	v := b.get(n)
+	if gt.copies(v) > 1 {
+		b.set(n, v-1<<uint(gt.fBits))
+		atomic.AddInt64(&gt.nDups, -1)
 		return true
 	}
 
-	// we could not insert it anywhere
-	return false
+	// keep track of the bucket fill levels
+	k := b.load()
+	atomic.AddInt64(&gt.fill[k], -1)
+	atomic.AddInt64(&gt.fill[k-1], 1)
+	atomic.AddInt64(&gt.nItems, -1)
+
+	// free the slot and return
+	b.clear(n)
+	return true
 }
 
-// has will check if a given bucket contains fingerprint f.
-func (gt *GokooTable) has(i int, f []byte) bool {
+// growable will check that the given number of slots per bucket can be
+// multiplied by the growth factor without exceeding the dimensions we can
+// address.
+func (gt *GokooTable) growable(nSlots int) bool {
+	return nSlots <= (math.MaxInt-7)/gt.sBits/gt.nBuckets/gt.nGrowth
+}
 
-	// check all slots for this bucket
-	for n := 0; n < gt.nSlots; n++ {
+// accurate will check that the table keeps the false positive rate below its
+// limit when it is full after growing from the given number of slots.
+func (gt *GokooTable) accurate(nSlots int) bool {
 
-		// start index and stop index
-		o, b, e := gt.access(i, n)
+	if gt.maxFP == 0 {
+		return true
+	}
 
-		// check if spot is used
-		if !gt.occupied[o] {
-			continue
-		}
+	return estimateFPRate(nSlots*gt.nGrowth, gt.fBits, 1) <= gt.maxFP
+}
 
-		// check if values match
-		if bytes.Equal(gt.buckets[b:e], f) {
-			return true
+// grow will multiply the number of slots per bucket by the growth factor and
+// move all stored fingerprints into the new layout. We only keep fingerprints,
+// so we can not recompute indexes for a different number of buckets; growing
+// the buckets instead keeps every fingerprint valid in its current bucket. If
+// the grown table would be too large, it returns false and changes nothing.
+func (gt *GokooTable) grow() bool {
+
+	if !gt.growable(gt.nSlots) {
+		return false
+	}
+
+	// allocate the new buckets slice
+	nSlots := gt.nSlots * gt.nGrowth
+	buckets := make([]byte, packedLen(gt.nBuckets*nSlots, gt.sBits))
+
+	// copy each slot to the same slot in the enlarged bucket
+	for i := 0; i < gt.nBuckets; i++ {
+		b := gt.bucket(i)
+		for n := 0; n < gt.nSlots; n++ {
+			if !b.used(n) {
+				continue
+			}
+			setBits(buckets, i*nSlots+n, gt.sBits, b.get(n))
 		}
 	}
 
-	// we could not find the fingerpnint
-	return false
+	// the fill levels of all buckets stay the same, there are just more
+	fill := make([]int64, nSlots+1)
+	copy(fill, gt.fill)
+
+	// switch over to the new layout and make use of the new space to empty
+	// the stash
+	gt.nSlots = nSlots
+	gt.buckets = buckets
+	gt.fill = fill
+	gt.drain()
+
+	return true
 }
 
-// del will delete an item from the given bucket, if possible.
-func (gt *GokooTable) del(i int, f []byte) bool {
+// stashed will return the position of fingerprint f for the bucket pair i1
+// and i2 in the stash, or -1 if it is not stashed.
+func (gt *GokooTable) stashed(i1 int, i2 int, f uint32) int {
 
-	// check all slots for this bucket
-	for n := 0; n < gt.nSlots; n++ {
+	// check all victims in the stash
+	for v, vic := range gt.stash {
+		if vic.bucket != i1 && vic.bucket != i2 {
+			continue
+		}
+		if gt.fingerPrintOf(vic.f) == f {
+			return v
+		}
+	}
 
-		// start and stop indexes
-		o, b, e := gt.access(i, n)
+	// we could not find the fingerprint
+	return -1
+}
 
-		// check if spot is used
-		if !gt.occupied[o] {
+// countStash will return the number of copies of fingerprint f for the bucket
+// pair i1 and i2 in the stash.
+func (gt *GokooTable) countStash(i1 int, i2 int, f uint32) int {
+
+	count := 0
+	for _, vic := range gt.stash {
+		if vic.bucket != i1 && vic.bucket != i2 {
 			continue
 		}
+		if gt.fingerPrintOf(vic.f) == f {
+			count += gt.copies(vic.f)
+		}
+	}
 
-		// check if values match
-		if bytes.Equal(gt.buckets[b:e], f) {
-			gt.occupied[o] = false
-			return true
+	return count
+}
+
+// bumpStash will increment the counter of fingerprint f for the bucket pair
+// i1 and i2 in the stash, if it has not reached its maximum yet.
+func (gt *GokooTable) bumpStash(i1 int, i2 int, f uint32) bool {
+
+	for v, vic := range gt.stash {
+		if vic.bucket != i1 && vic.bucket != i2 {
+			continue
+		}
+		if gt.fingerPrintOf(vic.f) != f || gt.copies(vic.f) == 1<<counterBits {
+			continue
 		}
+		gt.stash[v].f += 1 << uint(gt.fBits)
+		atomic.AddInt64(&gt.nDups, 1)
+		return true
 	}
 
-	// we could not delete the fingerprint
 	return false
 }
 
-// evict will evict a fingerprint from the bucket to insert the new one.
-func (gt *GokooTable) evict(i int, f []byte) []byte {
+// drain will try to move fingerprints from the stash back into their buckets.
+func (gt *GokooTable) drain() {
 
-	// pick a random slot for this bucket
-	n := rand.Int() % gt.nSlots
-	_, b, e := gt.access(i, n)
+	// keep all victims that still can't be placed in either of their buckets
+	stash := gt.stash[:0]
+	for _, vic := range gt.stash {
+		if gt.add(vic.bucket, vic.f) {
+			continue
+		}
+		if gt.add(gt.secondaryIndex(vic.bucket, vic.f), vic.f) {
+			continue
+		}
+		stash = append(stash, vic)
+	}
+	gt.setStash(stash)
+}
 
-	// get the old fingerprint and replace
-	fOld := make([]byte, len(f))
-	copy(fOld, gt.buckets[b:e])
-	copy(gt.buckets[b:e], f)
+// setStash will replace the stash and publish its new size to lock-free
+// readers.
+func (gt *GokooTable) setStash(stash []victim) {
+	gt.stash = stash
+	atomic.StoreInt64(&gt.nStashed, int64(len(stash)))
+}
+
+// evict will evict a fingerprint from the bucket to insert the new one and
+// return the slot it used.
+func (gt *GokooTable) evict(i int, f uint32) (int, uint32) {
 
-	// return old fingerprint
-	return fOld
+	// pick a random slot for this bucket
+	b := gt.bucket(i)
+	n := gt.rnd.Int() % gt.nSlots
+
+	// get the old fingerprint and replace, while readers can't rely on this
+	// bucket until the fingerprint was placed again
+	b.begin()
+	fOld := b.get(n)
+	b.set(n, f)
+	atomic.AddInt64(&gt.nKicks, 1)
+
+	// return slot and old fingerprint
+	return n, fOld
 }
@@ -9,6 +9,29 @@ import (
 	"testing"
 )
 
+// randomItems will create the given number of items of random byte slices.
+func randomItems(t testing.TB, count int) []*bytes.Buffer {
+
+	items := make([]*bytes.Buffer, count)
+	for i := 0; i < count; i++ {
+
+		// create item and decide how many bytes we will write
+		nBytes := rand.Int() % 256
+		slice := make([]byte, nBytes)
+
+		// get the required number of random bytes
+		_, err := crand.Read(slice)
+		if err != nil {
+			t.Errorf("could not get random bytes")
+		}
+
+		// buffer the bytes as item so we can implement the item interface
+		items[i] = bytes.NewBuffer(slice)
+	}
+
+	return items
+}
+
 func TestNew(t *testing.T) {
 
 	// test empty construction
@@ -35,7 +58,7 @@ func TestNew(t *testing.T) {
 	if err != nil {
 		t.Errorf("could not construct with custom bucket size: %v", err)
 	}
-	if len(gt.buckets)/gt.nSlots/gt.nBytes != nBuckets {
+	if len(gt.buckets)*8/gt.nSlots/gt.fBits != nBuckets {
 		t.Errorf("did not register custom bucket size")
 	}
 
@@ -45,7 +68,7 @@ func TestNew(t *testing.T) {
 	if err != nil {
 		t.Errorf("could not construct with custom slot size: %v", err)
 	}
-	if len(gt.buckets)/gt.nBuckets/gt.nBytes != nSlots {
+	if len(gt.buckets)*8/gt.nBuckets/gt.fBits != nSlots {
 		t.Errorf("did not register custom slot size")
 	}
 
@@ -59,6 +82,26 @@ func TestNew(t *testing.T) {
 		t.Errorf("did not register custom fingerprint size")
 	}
 
+	// test construction with custom fingerprint bits
+	fBits := 12
+	gt, err = New(SetFingerprintBits(fBits))
+	if err != nil {
+		t.Errorf("could not construct with custom fingerprint bits: %v", err)
+	}
+	if len(gt.buckets)*8/gt.nBuckets/gt.nSlots != fBits {
+		t.Errorf("did not register custom fingerprint bits")
+	}
+
+	// test construction with invalid fingerprint bits
+	_, err = New(SetFingerprintBits(3))
+	if err == nil {
+		t.Errorf("could construct with too few fingerprint bits")
+	}
+	_, err = New(SetFingerprintBits(33))
+	if err == nil {
+		t.Errorf("could construct with too many fingerprint bits")
+	}
+
 	// test construction with custom number of tries
 	nTries := 3
 	gt, err = New(SetNumTries(nTries))
@@ -68,63 +111,276 @@ func TestNew(t *testing.T) {
 	if gt.nTries != nTries {
 		t.Errorf("did not register custom number of tries")
 	}
+
+	// test construction with custom growth factor
+	nGrowth := 3
+	gt, err = New(SetGrowthFactor(nGrowth))
+	if err != nil {
+		t.Errorf("could not construct with custom growth factor: %v", err)
+	}
+	if gt.nGrowth != nGrowth {
+		t.Errorf("did not register custom growth factor")
+	}
+
+	// test construction with custom stash size
+	nStash := 3
+	gt, err = New(SetStashSize(nStash))
+	if err != nil {
+		t.Errorf("could not construct with custom stash size: %v", err)
+	}
+	if gt.nStash != nStash {
+		t.Errorf("did not register custom stash size")
+	}
+
+	// test construction with invalid growth factor
+	_, err = New(SetGrowthFactor(1))
+	if err == nil {
+		t.Errorf("could construct with invalid growth factor")
+	}
+	_, err = New(SetNumBuckets(4), SetNumSlots(1), SetRebuild(true),
+		SetGrowthFactor(1<<62))
+	if err == nil {
+		t.Errorf("could construct with overflowing growth factor")
+	}
+
+	// test construction with invalid false positive limits
+	for _, rate := range []float64{-0.1, 1.5, math.NaN()} {
+		_, err = New(SetMaxFPRate(rate))
+		if err == nil {
+			t.Errorf("could construct with false positive limit %v", rate)
+		}
+	}
 }
 
-func TestIndexReversal(t *testing.T) {
+func TestSipHashKeys(t *testing.T) {
 
-	// create 100 items of random byte slices
-	count := 100
-	items := make([]*bytes.Buffer, count)
-	for i := 0; i < count; i++ {
+	// zero keys are the same as the unkeyed hash, other keys differ
+	input := []byte("gokoo")
+	if !bytes.Equal(NewSipHash(0, 0)(input), SipHash(input)) {
+		t.Errorf("zero keys differ from unkeyed hash")
+	}
+	if bytes.Equal(NewSipHash(1, 2)(input), SipHash(input)) {
+		t.Errorf("keyed hash same as unkeyed hash")
+	}
 
-		// create item and decide how many bytes we will write
-		nBytes := rand.Int() % 256
-		slice := make([]byte, nBytes)
+	// fixed keys are remembered by the table
+	gt, _ := New(SetSipHashKeys(1, 2))
+	if !gt.keyed || gt.k0 != 1 || gt.k1 != 2 {
+		t.Errorf("did not register hash keys")
+	}
+	if !bytes.Equal(gt.hash(input), NewSipHash(1, 2)(input)) {
+		t.Errorf("did not use keyed hash")
+	}
+	if gt.hash64(input) != NewSipHash64(1, 2)(input) {
+		t.Errorf("did not use keyed 64-bit hash")
+	}
 
-		// get the required number of random bytes
-		_, err := crand.Read(slice)
-		if err != nil {
-			t.Errorf("could not get random bytes")
+	// random keys are generated for every table
+	gt1, _ := New(SetRandomSipHashKeys())
+	gt2, _ := New(SetRandomSipHashKeys())
+	if !gt1.keyed || gt1.k0 == gt2.k0 && gt1.k1 == gt2.k1 {
+		t.Errorf("did not generate random keys")
+	}
+
+	// setting another hash function afterwards drops the keys
+	gt, _ = New(SetRandomSipHashKeys(), SetHashFunc(Sha256Hash))
+	if gt.keyed {
+		t.Errorf("keys kept for other hash function")
+	}
+}
+
+func TestHash64(t *testing.T) {
+
+	// the 64-bit versions must match the first 8 bytes of the originals
+	input := []byte("gokoo")
+	pairs := []struct {
+		hash   GokooHash
+		hash64 GokooHash64
+	}{
+		{DummyHash, DummyHash64},
+		{Sha256Hash, Sha256Hash64},
+		{SipHash, SipHash64},
+		{NewSipHash(1, 2), NewSipHash64(1, 2)},
+	}
+	for _, pair := range pairs {
+		if hashValue(pair.hash(input)) != pair.hash64(input) {
+			t.Errorf("64-bit hash mismatch")
 		}
+	}
 
-		// buffer the bytes as item so we can implement the item interface
-		items[i] = bytes.NewBuffer(slice)
+	// bundled hash functions are replaced by their 64-bit versions
+	gt, _ := New(SetHashFunc(SipHash))
+	f1 := reflect.ValueOf(SipHash64)
+	f2 := reflect.ValueOf(gt.hash64)
+	if f1.Pointer() != f2.Pointer() {
+		t.Errorf("bundled hash function not replaced")
 	}
 
-	// get first and second hash and compare
-	gt, _ := New()
+	// custom hash functions are converted
+	custom := func(input []byte) []byte { return Sha256Hash(input) }
+	gt, _ = New(SetHashFunc(custom))
+	if gt.hash64(input) != Sha256Hash64(input) {
+		t.Errorf("custom hash function not converted")
+	}
+
+	// 64-bit hash functions can be set directly
+	gt, err := New(SetHashFunc64(SipHash64), SetNumBuckets(64))
+	if err != nil {
+		t.Errorf("could not construct with 64-bit hash function: %v", err)
+	}
+	for _, item := range randomItems(t, 100) {
+		if !gt.Insert(item) || !gt.Lookup(item) {
+			t.Errorf("insert error with 64-bit hash function")
+		}
+	}
+}
+
+func TestLookupAllocs(t *testing.T) {
+
+	// fill a table with a bundled hash function
+	items := randomItems(t, 100)
+	gt, _ := New(SetHashFunc(SipHash), SetNumBuckets(64))
 	for _, item := range items {
-		hash := gt.hash(item.Bytes())
-		f := gt.fingerPrint(hash)
-		i1 := gt.primaryIndex(hash)
-		i2 := gt.secondaryIndex(i1, f)
-		i1rev := gt.secondaryIndex(i2, f)
-		if i1 != i1rev {
-			t.Errorf("primary index mismatch: %v != %v", i1, i1rev)
+		gt.Insert(item)
+	}
+
+	// looking up items must not allocate
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, item := range items {
+			gt.Lookup(item)
 		}
+	})
+	if allocs != 0 {
+		t.Errorf("lookup allocates: %v allocs per run", allocs)
 	}
 }
 
-func TestInsertLookupRemove(t *testing.T) {
+func TestIndexBits(t *testing.T) {
+
+	// check that very large tables can use an 8 byte hash
+	gt := &GokooTable{
+		hash:     SipHash,
+		nBuckets: 100000000,
+		nSlots:   4,
+		fBits:    16,
+		nGrowth:  2,
+	}
+	err := gt.configure()
+	if err != nil {
+		t.Errorf("could not configure large table: %v", err)
+	}
+	if gt.iBits != 27 {
+		t.Errorf("wrong number of index bits: %v", gt.iBits)
+	}
+
+	// check that we still fail if the bits really don't suffice
+	gt.fBits = 32
+	gt.nBuckets = 1 << 33
+	err = gt.configure()
+	if err == nil {
+		t.Errorf("could configure table with insufficient hash bits")
+	}
+
+	// check that index and fingerprint are taken from the right bits
+	gt, _ = New(SetHashFunc(DummyHash), SetNumBuckets(16), SetNumBytes(2))
+	hash := gt.hash64([]byte{0x35, 0x12, 0xab, 0xff})
+	i := gt.primaryIndex(hash)
+	if i != 0x5 {
+		t.Errorf("wrong primary index: %x", i)
+	}
+	f := gt.fingerPrint(hash)
+	if f != 0xb123 {
+		t.Errorf("wrong fingerprint: %x", f)
+	}
+}
+
+func TestEmptyFingerprint(t *testing.T) {
+
+	// an item whose fingerprint bits are all zero
+	gt, _ := New(SetHashFunc(DummyHash), SetNumBuckets(16))
+	item := bytes.NewBuffer([]byte{0x05})
+	f := gt.fingerPrint(gt.hash64(item.Bytes()))
+	if f == 0 {
+		t.Fatalf("fingerprint not remapped from empty value")
+	}
+
+	// it must still be possible to insert, lookup and remove it
+	if !gt.Insert(item) {
+		t.Errorf("could not insert item with remapped fingerprint")
+	}
+	if !gt.Lookup(item) {
+		t.Errorf("could not lookup item with remapped fingerprint")
+	}
+	if !gt.Remove(item) {
+		t.Errorf("could not remove item with remapped fingerprint")
+	}
+	if gt.Lookup(item) {
+		t.Errorf("item found after removal")
+	}
+}
+
+func TestIndexReversal(t *testing.T) {
 
 	// create 100 items of random byte slices
 	count := 100
-	items := make([]*bytes.Buffer, count)
-	for i := 0; i < count; i++ {
+	items := randomItems(t, count)
 
-		// create item and decide how many bytes we will write
-		nBytes := rand.Int() % 256
-		slice := make([]byte, nBytes)
+	// get first and second hash and compare for odd, prime and power of two
+	// numbers of buckets
+	for _, nBuckets := range []int{1, 2, 3, 5, 7, 8, 13, 40, 97, 256, 257,
+		953, 3 << 8} {
+		gt, _ := New(SetNumBuckets(nBuckets))
+		for _, item := range items {
+			hash := gt.hash64(item.Bytes())
+			f := gt.fingerPrint(hash)
+			i1 := gt.primaryIndex(hash)
+			i2 := gt.secondaryIndex(i1, f)
+			if i2 < 0 || i2 >= nBuckets {
+				t.Errorf("secondary index out of range: %v", i2)
+			}
+			i1rev := gt.secondaryIndex(i2, f)
+			if i1 != i1rev {
+				t.Errorf("primary index mismatch for %v buckets: %v != %v",
+					nBuckets, i1, i1rev)
+			}
+		}
 
-		// get the required number of random bytes
-		_, err := crand.Read(slice)
-		if err != nil {
-			t.Errorf("could not get random bytes")
+		// check every bucket with every possible fingerprint
+		for i := 0; i < nBuckets; i++ {
+			for b := 0; b < 256; b++ {
+				f := uint32(b)
+				irev := gt.secondaryIndex(gt.secondaryIndex(i, f), f)
+				if i != irev {
+					t.Errorf("index mismatch for %v buckets: %v != %v",
+						nBuckets, i, irev)
+				}
+			}
 		}
 
-		// buffer the bytes as item so we can implement the item interface
-		items[i] = bytes.NewBuffer(slice)
+		// primary indexes must be spread evenly over all buckets, which is
+		// hardest for numbers just above a power of two
+		hits := make([]int, nBuckets)
+		rnd := rand.New(rand.NewSource(int64(nBuckets)))
+		for n := 0; n < 1000*nBuckets; n++ {
+			hits[gt.primaryIndex(rnd.Uint64())]++
+		}
+		least, most := hits[0], hits[0]
+		for _, h := range hits {
+			least = min(least, h)
+			most = max(most, h)
+		}
+		if float64(most) > 1.5*float64(least) {
+			t.Errorf("primary indexes biased for %v buckets: %v to %v",
+				nBuckets, least, most)
+		}
 	}
+}
+
+func TestInsertLookupRemove(t *testing.T) {
+
+	// create 100 items of random byte slices
+	count := 100
+	items := randomItems(t, count)
 
 	// create new cuckoo filter
 	slots := 3
@@ -173,3 +429,330 @@ func TestInsertLookupRemove(t *testing.T) {
 		t.Errorf("delete error: %v not deleted", deleteErr)
 	}
 }
+
+func TestRebuild(t *testing.T) {
+
+	// create 100 items of random byte slices
+	count := 100
+	items := randomItems(t, count)
+
+	// create a cuckoo filter that is much too small for all items
+	cf, _ := New(
+		SetNumBuckets(4),
+		SetNumSlots(1),
+		SetNumTries(8),
+		SetRebuild(true),
+	)
+
+	// insert the 100 items, which should grow the table when needed
+	insertErr := 0
+	for _, item := range items {
+		if !cf.Insert(item) {
+			insertErr++
+		}
+	}
+
+	// check if all items were inserted and the table grew
+	if insertErr != 0 {
+		t.Errorf("insert error: %v not inserted", insertErr)
+	}
+	if cf.nSlots*cf.nBuckets < count {
+		t.Errorf("table did not grow: %v slots", cf.nSlots*cf.nBuckets)
+	}
+
+	// check that no item was lost while growing
+	lookupErr := 0
+	for _, item := range items {
+		if !cf.Lookup(item) {
+			lookupErr++
+		}
+	}
+	if lookupErr != 0 {
+		t.Errorf("lookup error: %v false negatives", lookupErr)
+	}
+
+	// once growing would overflow the dimensions, inserts must fail and
+	// leave the table as it was
+	cf, _ = New(
+		SetNumBuckets(4),
+		SetNumSlots(1),
+		SetNumTries(4),
+		SetRebuild(true),
+	)
+	cf.nGrowth = 1 << 62
+	failed := 0
+	for _, item := range items {
+		data, _ := cf.MarshalBinary()
+		if cf.Insert(item) {
+			continue
+		}
+		failed++
+		after, _ := cf.MarshalBinary()
+		if !bytes.Equal(data, after) {
+			t.Fatalf("failed insert changed the table")
+		}
+	}
+	if failed == 0 || cf.nSlots != 1 || cf.Stats().Failed != failed {
+		t.Errorf("table grew beyond its dimensions: %v slots", cf.nSlots)
+	}
+
+	// rebuilds must stop before the false positive rate passes its limit,
+	// which survives serialization
+	cf, _ = New(
+		SetNumBuckets(4),
+		SetNumSlots(4),
+		SetFingerprintBits(8),
+		SetRebuild(true),
+		SetMaxFPRate(0.1),
+	)
+	failed = 0
+	for _, item := range items {
+		if !cf.Insert(item) {
+			failed++
+		}
+	}
+	stats := cf.Stats()
+	if failed == 0 || stats.Failed != failed || cf.nSlots != 8 ||
+		stats.FPRate > 0.1 {
+		t.Errorf("rebuilt beyond false positive limit: %v slots, %v rate",
+			cf.nSlots, stats.FPRate)
+	}
+	data, _ := cf.MarshalBinary()
+	cf2, _ := New()
+	err := cf2.UnmarshalBinary(data)
+	if err != nil || cf2.maxFP != 0.1 {
+		t.Errorf("false positive limit not restored: %v", cf2.maxFP)
+	}
+}
+
+func TestSaturation(t *testing.T) {
+
+	// create many more items than the table can hold
+	count := 1000
+	items := randomItems(t, count)
+	cf, _ := New(
+		SetNumBuckets(64),
+		SetNumSlots(4),
+		SetNumTries(32),
+	)
+
+	// insert all items and remember which ones were accepted
+	inserted := make([]*bytes.Buffer, 0, count)
+	for _, item := range items {
+		if cf.Insert(item) {
+			inserted = append(inserted, item)
+		}
+	}
+
+	// check that the table actually ran full
+	if len(inserted) == count {
+		t.Fatalf("table did not saturate")
+	}
+
+	// failed inserts must never push out previously inserted items
+	lookupErr := 0
+	for _, item := range inserted {
+		if !cf.Lookup(item) {
+			lookupErr++
+		}
+	}
+	if lookupErr != 0 {
+		t.Errorf("lookup error: %v false negatives", lookupErr)
+	}
+}
+
+func TestStash(t *testing.T) {
+
+	// create many more items than the table can hold
+	count := 1000
+	items := randomItems(t, count)
+	nStash := 4
+	cf, _ := New(
+		SetNumBuckets(16),
+		SetNumSlots(2),
+		SetNumTries(8),
+		SetStashSize(nStash),
+	)
+
+	// insert until the stash is full, which happens with the first failure
+	inserted := make([]*bytes.Buffer, 0, count)
+	for _, item := range items {
+		if !cf.Insert(item) {
+			break
+		}
+		inserted = append(inserted, item)
+	}
+	if len(cf.stash) != nStash {
+		t.Fatalf("stash not filled: %v victims", len(cf.stash))
+	}
+
+	// all inserted items, including stashed ones, must be found
+	lookupErr := 0
+	for _, item := range inserted {
+		if !cf.Lookup(item) {
+			lookupErr++
+		}
+	}
+	if lookupErr != 0 {
+		t.Errorf("lookup error: %v false negatives", lookupErr)
+	}
+
+	// stashed items must survive a serialization round trip
+	data, _ := cf.MarshalBinary()
+	cf2, _ := New()
+	err := cf2.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf("could not unmarshal table: %v", err)
+	}
+	if len(cf2.stash) != nStash {
+		t.Errorf("stash not restored: %v victims", len(cf2.stash))
+	}
+
+	// removing items makes room for the stash to drain back into the buckets
+	deleteErr := 0
+	for _, item := range inserted {
+		if !cf.Remove(item) {
+			deleteErr++
+		}
+	}
+	if deleteErr != 0 {
+		t.Errorf("delete error: %v not deleted", deleteErr)
+	}
+	if len(cf.stash) != 0 {
+		t.Errorf("stash not drained: %v victims", len(cf.stash))
+	}
+}
+
+func TestRandSource(t *testing.T) {
+
+	// create two saturated tables with the same seed
+	items := randomItems(t, 500)
+	tables := make([]*GokooTable, 2)
+	for n := range tables {
+		tables[n], _ = New(
+			SetNumBuckets(32),
+			SetNumTries(16),
+			SetRandSource(rand.NewSource(42)),
+		)
+		for _, item := range items {
+			tables[n].Insert(item)
+		}
+	}
+
+	// they must have made the same eviction decisions
+	if !bytes.Equal(tables[0].buckets, tables[1].buckets) {
+		t.Errorf("bucket contents differ for the same random source")
+	}
+	data0, _ := tables[0].MarshalBinary()
+	data1, _ := tables[1].MarshalBinary()
+	if !bytes.Equal(data0, data1) {
+		t.Errorf("snapshots differ for the same random source")
+	}
+
+	// concurrent tables must keep their source when restored from a snapshot
+	snapshots := make([][]byte, 2)
+	for n := range snapshots {
+		ct, _ := NewConcurrent(
+			SetNumBuckets(32),
+			SetNumTries(16),
+			SetRandSource(rand.NewSource(42)),
+		)
+		for _, item := range items[:64] {
+			ct.Insert(item)
+		}
+		var buf bytes.Buffer
+		ct.WriteTo(&buf)
+		_, err := ct.ReadFrom(&buf)
+		if err != nil {
+			t.Fatalf("could not read snapshot: %v", err)
+		}
+		for _, item := range items[64:] {
+			ct.Insert(item)
+		}
+		buf.Reset()
+		ct.WriteTo(&buf)
+		snapshots[n] = buf.Bytes()
+	}
+	if !bytes.Equal(snapshots[0], snapshots[1]) {
+		t.Errorf("restored snapshots differ for the same random source")
+	}
+}
+
+func TestCounting(t *testing.T) {
+
+	// counters take bits from the slots
+	_, err := New(SetCounting(true), SetFingerprintBits(29))
+	if err == nil {
+		t.Errorf("could construct counting table with 29 bit fingerprints")
+	}
+
+	// insert the same item much more often than it has slots
+	item := bytes.NewBufferString("gokoo")
+	count := 100
+	for _, atomic := range []bool{false, true} {
+		gt, _ := New(
+			SetCounting(true),
+			SetAtomicBuckets(atomic),
+			SetFingerprintBits(12),
+		)
+		for n := 0; n < count; n++ {
+			if !gt.Insert(item) {
+				t.Fatalf("insert error for copy %v", n)
+			}
+		}
+		if gt.CountItem(item) != count || gt.Count() != count {
+			t.Errorf("wrong count of copies: %v, %v", gt.CountItem(item),
+				gt.Count())
+		}
+		slots := (count + 1<<counterBits - 1) / (1 << counterBits)
+		if gt.Stats().BucketFill[0] == gt.nBuckets ||
+			gt.LoadFactor() != float64(slots)/float64(gt.Capacity()) {
+			t.Errorf("copies use wrong number of slots: %v",
+				gt.Stats().BucketFill)
+		}
+
+		// the counters survive serialization
+		data, _ := gt.MarshalBinary()
+		gt2, _ := New()
+		err = gt2.UnmarshalBinary(data)
+		if err != nil {
+			t.Fatalf("could not unmarshal counting table: %v", err)
+		}
+		if gt2.CountItem(item) != count || gt2.Count() != count {
+			t.Errorf("wrong count of copies after unmarshal: %v, %v",
+				gt2.CountItem(item), gt2.Count())
+		}
+
+		// every copy has to be removed
+		for n := 0; n < count; n++ {
+			if !gt.Remove(item) {
+				t.Fatalf("delete error for copy %v", n)
+			}
+		}
+		if gt.Remove(item) || gt.Lookup(item) || gt.Count() != 0 {
+			t.Errorf("item still found after removing all copies")
+		}
+	}
+
+	// without counting mode, copies take slots but are counted as well
+	gt, _ := New()
+	for n := 0; n < 3; n++ {
+		gt.Insert(item)
+	}
+	if gt.CountItem(item) != 3 || gt.LoadFactor() != 3/float64(gt.Capacity()) {
+		t.Errorf("wrong count of copies without counting mode: %v",
+			gt.CountItem(item))
+	}
+
+	// concurrent tables count copies on their fast path
+	ct, _ := NewConcurrent(SetCounting(true))
+	for n := 0; n < count; n++ {
+		if !ct.Insert(item) {
+			t.Fatalf("concurrent insert error for copy %v", n)
+		}
+	}
+	if ct.CountItem(item) != count || ct.Count() != count {
+		t.Errorf("wrong concurrent count of copies: %v, %v",
+			ct.CountItem(item), ct.Count())
+	}
+}
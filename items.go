@@ -0,0 +1,157 @@
+package gokoo
+
+import (
+	"encoding"
+	"encoding/binary"
+	"sync"
+	"unsafe"
+)
+
+// byteItem is an item made of a byte slice.
+type byteItem []byte
+
+func (bi byteItem) Bytes() []byte {
+	return bi
+}
+
+// BinaryItem will create an item from the binary encoding of a value, so that
+// values implementing encoding.BinaryMarshaler can be stored directly. The
+// value is encoded once, so the item can be reused for several operations.
+func BinaryItem(value encoding.BinaryMarshaler) (GokooItem, error) {
+
+	data, err := value.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return byteItem(data), nil
+}
+
+// stringBytes will return the bytes of a string without copying them. This is
+// safe because the hash functions only read their input.
+func stringBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
+// scratch holds items for encoding integers, so we don't have to allocate one
+// for every operation and stay safe for concurrent use.
+var scratch = sync.Pool{
+	New: func() any { return &hashed{data: make([]byte, 8)} },
+}
+
+// withUint64 will run the operation on the integer encoded as eight bytes in
+// little-endian order.
+func withUint64(value uint64, op func(*hashed) bool) bool {
+
+	h := scratch.Get().(*hashed)
+	binary.LittleEndian.PutUint64(h.data, value)
+	ok := op(h)
+
+	// the item must not keep the hash of this value, or the table alive
+	h.gt = nil
+	scratch.Put(h)
+
+	return ok
+}
+
+// InsertBytes will try to add the item with the given bytes to the cuckoo
+// table.
+func (gt *GokooTable) InsertBytes(data []byte) bool {
+	return gt.insert(&hashed{data: data})
+}
+
+// InsertString will try to add the item with the bytes of the given string to
+// the cuckoo table, without copying them.
+func (gt *GokooTable) InsertString(s string) bool {
+	return gt.insert(&hashed{data: stringBytes(s)})
+}
+
+// InsertUint64 will try to add the integer to the cuckoo table, as the item
+// with its eight bytes in little-endian order.
+func (gt *GokooTable) InsertUint64(value uint64) bool {
+	return withUint64(value, gt.insert)
+}
+
+// LookupBytes will check if the cuckoo table contains the item with the given
+// bytes.
+func (gt *GokooTable) LookupBytes(data []byte) bool {
+	return gt.lookup(&hashed{data: data})
+}
+
+// LookupString will check if the cuckoo table contains the item with the bytes
+// of the given string.
+func (gt *GokooTable) LookupString(s string) bool {
+	return gt.lookup(&hashed{data: stringBytes(s)})
+}
+
+// LookupUint64 will check if the cuckoo table contains the integer.
+func (gt *GokooTable) LookupUint64(value uint64) bool {
+	return withUint64(value, gt.lookup)
+}
+
+// RemoveBytes will remove the item with the given bytes from the cuckoo table.
+func (gt *GokooTable) RemoveBytes(data []byte) bool {
+	return gt.remove(&hashed{data: data})
+}
+
+// RemoveString will remove the item with the bytes of the given string from
+// the cuckoo table.
+func (gt *GokooTable) RemoveString(s string) bool {
+	return gt.remove(&hashed{data: stringBytes(s)})
+}
+
+// RemoveUint64 will remove the integer from the cuckoo table.
+func (gt *GokooTable) RemoveUint64(value uint64) bool {
+	return withUint64(value, gt.remove)
+}
+
+// InsertBytes will try to add the item with the given bytes to the cuckoo
+// table.
+func (ct *ConcurrentTable) InsertBytes(data []byte) bool {
+	return ct.insert(&hashed{data: data})
+}
+
+// InsertString will try to add the item with the bytes of the given string to
+// the cuckoo table, without copying them.
+func (ct *ConcurrentTable) InsertString(s string) bool {
+	return ct.insert(&hashed{data: stringBytes(s)})
+}
+
+// InsertUint64 will try to add the integer to the cuckoo table, as the item
+// with its eight bytes in little-endian order.
+func (ct *ConcurrentTable) InsertUint64(value uint64) bool {
+	return withUint64(value, ct.insert)
+}
+
+// LookupBytes will check if the cuckoo table contains the item with the given
+// bytes.
+func (ct *ConcurrentTable) LookupBytes(data []byte) bool {
+	return ct.lookup(&hashed{data: data})
+}
+
+// LookupString will check if the cuckoo table contains the item with the bytes
+// of the given string.
+func (ct *ConcurrentTable) LookupString(s string) bool {
+	return ct.lookup(&hashed{data: stringBytes(s)})
+}
+
+// LookupUint64 will check if the cuckoo table contains the integer.
+func (ct *ConcurrentTable) LookupUint64(value uint64) bool {
+	return withUint64(value, ct.lookup)
+}
+
+// RemoveBytes will remove the item with the given bytes from the cuckoo table.
+func (ct *ConcurrentTable) RemoveBytes(data []byte) bool {
+	return ct.remove(&hashed{data: data})
+}
+
+// RemoveString will remove the item with the bytes of the given string from
+// the cuckoo table.
+func (ct *ConcurrentTable) RemoveString(s string) bool {
+	return ct.remove(&hashed{data: stringBytes(s)})
+}
+
+// RemoveUint64 will remove the integer from the cuckoo table.
+func (ct *ConcurrentTable) RemoveUint64(value uint64) bool {
+	return withUint64(value, ct.remove)
+}
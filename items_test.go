@@ -0,0 +1,111 @@
+package gokoo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+// failingMarshaler is a value that can't be encoded.
+type failingMarshaler struct{}
+
+func (failingMarshaler) MarshalBinary() ([]byte, error) {
+	return nil, errors.New("can't encode")
+}
+
+func TestItemAdapters(t *testing.T) {
+
+	gt, _ := New(SetHashFunc(SipHash), SetNumBuckets(64))
+
+	// strings are the same items as their bytes
+	if !gt.InsertString("gokoo") {
+		t.Fatalf("could not insert string")
+	}
+	if !gt.LookupBytes([]byte("gokoo")) ||
+		!gt.Lookup(bytes.NewBufferString("gokoo")) {
+		t.Errorf("string not found as bytes")
+	}
+	if !gt.RemoveBytes([]byte("gokoo")) || gt.LookupString("gokoo") {
+		t.Errorf("string not removed as bytes")
+	}
+
+	// integers are the same items as their little-endian bytes
+	var data [8]byte
+	binary.LittleEndian.PutUint64(data[:], 1234567)
+	if !gt.InsertBytes(data[:]) {
+		t.Fatalf("could not insert bytes")
+	}
+	if !gt.LookupUint64(1234567) || gt.LookupUint64(7654321) {
+		t.Errorf("wrong lookup result for integer")
+	}
+	if !gt.RemoveUint64(1234567) || gt.Count() != 0 {
+		t.Errorf("integer not removed")
+	}
+
+	// values are stored with their binary encoding
+	addr := netip.MustParseAddr("192.0.2.1")
+	item, err := BinaryItem(addr)
+	if err != nil {
+		t.Fatalf("could not create item from address: %v", err)
+	}
+	encoded, _ := addr.MarshalBinary()
+	if !gt.Insert(item) || !gt.LookupBytes(encoded) {
+		t.Errorf("address not found by its encoding")
+	}
+	_, err = BinaryItem(failingMarshaler{})
+	if err == nil {
+		t.Errorf("could create item from failing marshaler")
+	}
+
+	// the concurrent table has the same adapters
+	ct, _ := NewConcurrent(SetHashFunc(SipHash), SetNumBuckets(64))
+	if !ct.InsertString("gokoo") || !ct.InsertUint64(42) ||
+		!ct.InsertBytes([]byte{1, 2, 3}) {
+		t.Fatalf("could not insert into concurrent table")
+	}
+	if !ct.LookupString("gokoo") || !ct.LookupUint64(42) ||
+		!ct.LookupBytes([]byte{1, 2, 3}) {
+		t.Errorf("lookup error in concurrent table")
+	}
+	if !ct.RemoveString("gokoo") || !ct.RemoveUint64(42) ||
+		!ct.RemoveBytes([]byte{1, 2, 3}) || ct.Count() != 0 {
+		t.Errorf("delete error in concurrent table")
+	}
+}
+
+func TestItemAdapterAllocs(t *testing.T) {
+
+	// none of the adapters must allocate to wrap their argument, but the
+	// integer buffers are pooled and can't be counted with the race detector
+	if raceEnabled {
+		t.Skip("pooled buffers are dropped with the race detector")
+	}
+	gt, _ := New(SetHashFunc(SipHash), SetNumBuckets(64))
+	ct, _ := NewConcurrent(SetHashFunc(SipHash), SetNumBuckets(64))
+	s := string([]byte("gokoo"))
+	ops := map[string]func(){
+		"string": func() {
+			gt.InsertString(s)
+			gt.LookupString(s)
+			gt.RemoveString(s)
+		},
+		"integer": func() {
+			gt.InsertUint64(42)
+			gt.LookupUint64(42)
+			gt.RemoveUint64(42)
+		},
+		"concurrent": func() {
+			ct.InsertUint64(42)
+			ct.LookupString(s)
+			ct.RemoveUint64(42)
+		},
+	}
+	for name, op := range ops {
+		allocs := testing.AllocsPerRun(100, op)
+		if allocs != 0 {
+			t.Errorf("%v adapters allocate: %v", name, allocs)
+		}
+	}
+}
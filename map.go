@@ -0,0 +1,292 @@
+package gokoo
+
+import (
+	"errors"
+	"hash/maphash"
+	"math"
+	"math/bits"
+)
+
+// entry is a key and its value stored in a slot of a map, along with the hash
+// of the key, so we can move it to its other bucket without hashing again.
+type entry[K comparable, V any] struct {
+	hash  uint64
+	key   K
+	value V
+	used  bool
+}
+
+// Map is a cuckoo hash map, which stores every key in one of two buckets with
+// several slots each, using the same indexes as the cuckoo filter. Unlike the
+// filter, it keeps the full keys, so lookups are exact and take constant time
+// in the worst case. When a key can't be placed, the map grows and moves all
+// entries to their new buckets.
+type Map[K comparable, V any] struct {
+	gt      *GokooTable
+	seed    maphash.Seed
+	entries []entry[K, V]
+	count   int
+}
+
+// NewMap will create a new cuckoo hash map. Of the options for cuckoo filters,
+// those setting the number of buckets and slots, the number of tries, the
+// growth factor and the random source apply to maps as well.
+func NewMap[K comparable, V any](options ...func(*GokooTable)) (*Map[K, V],
+	error) {
+
+	// the table only provides the dimensions and indexes, without storage
+	gt := &GokooTable{
+		nBuckets: 8,
+		nSlots:   4,
+		nTries:   512,
+		nGrowth:  2,
+	}
+	for _, option := range options {
+		option(gt)
+	}
+	gt.hash = nil
+	gt.hash64 = nil
+	gt.fBits = mapBits(gt.nBuckets)
+	gt.rebuild = false
+	gt.lockFree = false
+	gt.counting = false
+	gt.nStash = 0
+	err := gt.configure()
+	if err != nil {
+		return nil, err
+	}
+	_, ok := mapGrowth(gt)
+	if !ok {
+		return nil, errors.New("growth factor too large for map dimensions")
+	}
+
+	m := &Map[K, V]{
+		gt:      gt,
+		seed:    maphash.MakeSeed(),
+		entries: make([]entry[K, V], gt.nBuckets*gt.nSlots),
+	}
+
+	return m, nil
+}
+
+// mapBits will return the number of fingerprint bits for a map with the given
+// number of buckets. The fingerprint decides where the second bucket is, so
+// we use as many bits of the hash as we can to spread entries evenly.
+func mapBits(nBuckets int) int {
+
+	fBits := 64 - bits.Len(uint(nBuckets-1))
+	if fBits > 32 {
+		fBits = 32
+	}
+
+	return fBits
+}
+
+// mapGrowth will return the table of the map after it grows, unless its
+// dimensions would get too large.
+func mapGrowth(gt *GokooTable) (*GokooTable, bool) {
+
+	if gt.nBuckets > math.MaxInt/gt.nGrowth {
+		return nil, false
+	}
+
+	grown := *gt
+	grown.nBuckets *= gt.nGrowth
+	grown.fBits = mapBits(grown.nBuckets)
+	if grown.configure() != nil {
+		return nil, false
+	}
+
+	return &grown, true
+}
+
+// slots will return the slots of bucket i.
+func (m *Map[K, V]) slots(i int) []entry[K, V] {
+	return m.entries[i*m.gt.nSlots : (i+1)*m.gt.nSlots]
+}
+
+// hash will return the hash of the key.
+func (m *Map[K, V]) hash(key K) uint64 {
+	return maphash.Comparable(m.seed, key)
+}
+
+// find will return the entry for the key with the given hash in its two
+// buckets, or nil if the map doesn't contain it.
+func (m *Map[K, V]) find(key K, hash uint64) *entry[K, V] {
+
+	// compare the hashes first, so we only compare keys that likely match
+	i1 := m.gt.primaryIndex(hash)
+	i2 := m.gt.secondaryIndex(i1, m.gt.fingerPrint(hash))
+	for _, i := range [2]int{i1, i2} {
+		slots := m.slots(i)
+		for n := range slots {
+			e := &slots[n]
+			if e.used && e.hash == hash && e.key == key {
+				return e
+			}
+		}
+	}
+
+	return nil
+}
+
+// Get will return the value stored for the key, and whether the map contains
+// it at all.
+func (m *Map[K, V]) Get(key K) (V, bool) {
+
+	e := m.find(key, m.hash(key))
+	if e == nil {
+		var zero V
+		return zero, false
+	}
+
+	return e.value, true
+}
+
+// Put will store the value for the key, replacing any previous value.
+func (m *Map[K, V]) Put(key K, value V) {
+
+	// replace the value of a key we already have
+	hash := m.hash(key)
+	e := m.find(key, hash)
+	if e != nil {
+		e.value = value
+		return
+	}
+
+	// otherwise, place a new entry, and grow if it doesn't fit
+	in := entry[K, V]{
+		hash:  hash,
+		key:   key,
+		value: value,
+		used:  true,
+	}
+	m.count++
+	left, ok := m.place(in)
+	if !ok {
+		m.grow(left)
+	}
+}
+
+// add will put the entry into a free slot of bucket i, if there is one.
+func (m *Map[K, V]) add(i int, in entry[K, V]) bool {
+
+	slots := m.slots(i)
+	for n := range slots {
+		if !slots[n].used {
+			slots[n] = in
+			return true
+		}
+	}
+
+	return false
+}
+
+// place will put the entry into one of its buckets, evicting other entries
+// just like the filter does. If it doesn't succeed, it returns the entry that
+// is left without a slot.
+func (m *Map[K, V]) place(in entry[K, V]) (entry[K, V], bool) {
+
+	// try to add to both buckets directly
+	gt := m.gt
+	i1 := gt.primaryIndex(in.hash)
+	if m.add(i1, in) {
+		return in, true
+	}
+	i2 := gt.secondaryIndex(i1, gt.fingerPrint(in.hash))
+	if m.add(i2, in) {
+		return in, true
+	}
+
+	// randomly pick i1 or i2 and keep evicting in that direction
+	i := i1
+	if gt.rnd.Int()%2 == 1 {
+		i = i2
+	}
+	for n := 0; n < gt.nTries; n++ {
+
+		// swap the entry with a random one in the bucket
+		slot := &m.slots(i)[gt.rnd.Int()%gt.nSlots]
+		in, *slot = *slot, in
+
+		// move the evicted entry to its other bucket
+		i = gt.secondaryIndex(i, gt.fingerPrint(in.hash))
+		if m.add(i, in) {
+			return in, true
+		}
+	}
+
+	return in, false
+}
+
+// grow will multiply the number of buckets by the growth factor and move all
+// entries to their new buckets, along with the one that was left without a
+// slot. As we keep the full hashes, we can compute new indexes, unlike for the
+// filter.
+func (m *Map[K, V]) grow(in entry[K, V]) {
+
+	// collect all entries, including the one that is left
+	all := make([]entry[K, V], 0, m.count)
+	for _, e := range m.entries {
+		if e.used {
+			all = append(all, e)
+		}
+	}
+	all = append(all, in)
+
+	// keep growing until all entries fit, which gets more likely every time
+	for {
+		// close to the largest dimensions, we only double the buckets
+		gt, ok := mapGrowth(m.gt)
+		if !ok {
+			double := *m.gt
+			double.nGrowth = 2
+			gt, ok = mapGrowth(&double)
+		}
+		if !ok {
+			panic("map can not grow any further") // beyond 2^56 entries
+		}
+		m.gt = gt
+		m.entries = make([]entry[K, V], gt.nBuckets*gt.nSlots)
+
+		for _, e := range all {
+			_, ok = m.place(e)
+			if !ok {
+				break
+			}
+		}
+		if ok {
+			return
+		}
+	}
+}
+
+// Delete will remove the key from the map, and return whether it was there.
+func (m *Map[K, V]) Delete(key K) bool {
+
+	e := m.find(key, m.hash(key))
+	if e == nil {
+		return false
+	}
+
+	*e = entry[K, V]{}
+	m.count--
+	return true
+}
+
+// Range will call the function for every key and value in the map, in no
+// particular order, until it returns false. The map must not be modified
+// while ranging over it.
+func (m *Map[K, V]) Range(fn func(key K, value V) bool) {
+
+	for _, e := range m.entries {
+		if e.used && !fn(e.key, e.value) {
+			return
+		}
+	}
+}
+
+// Len will return the number of keys in the map.
+func (m *Map[K, V]) Len() int {
+	return m.count
+}
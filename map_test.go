@@ -0,0 +1,143 @@
+package gokoo
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+
+	// start small, so the map has to grow many times
+	m, err := NewMap[int, string](SetNumBuckets(2), SetNumSlots(2))
+	if err != nil {
+		t.Fatalf("could not create map: %v", err)
+	}
+	_, err = NewMap[int, string](SetNumSlots(0))
+	if err == nil {
+		t.Errorf("could create map with invalid dimensions")
+	}
+	for _, options := range [][]func(*GokooTable){
+		{SetGrowthFactor(1 << 62)},
+		{SetNumBuckets(1 << 40), SetGrowthFactor(1 << 20)},
+	} {
+		_, err = NewMap[int, string](options...)
+		if err == nil {
+			t.Errorf("could create map with overflowing growth factor")
+		}
+	}
+
+	// a map that can't grow by its factor any more only doubles
+	big, err := NewMap[int, string](SetNumBuckets(2), SetNumSlots(1))
+	if err != nil {
+		t.Fatalf("could not create map: %v", err)
+	}
+	big.gt.nGrowth = 1 << 62
+	for k := 0; k < 100; k++ {
+		big.Put(k, "")
+	}
+	if big.Len() != 100 || big.gt.nGrowth != 2 {
+		t.Errorf("map did not fall back to doubling: %v", big.gt.nGrowth)
+	}
+
+	// the zero key is a valid key
+	m.Put(0, "zero")
+	value, ok := m.Get(0)
+	if !ok || value != "zero" {
+		t.Errorf("zero key not found")
+	}
+
+	// put many keys, and replace the value of some of them
+	count := 10000
+	for k := 1; k < count; k++ {
+		m.Put(k, "")
+	}
+	for k := 0; k < count; k += 2 {
+		m.Put(k, "even")
+	}
+	if m.Len() != count {
+		t.Errorf("wrong length: %v != %v", m.Len(), count)
+	}
+	if len(m.entries) > 4*count {
+		t.Errorf("map grew too much: %v slots for %v keys", len(m.entries),
+			count)
+	}
+	for k := 0; k < count; k++ {
+		value, ok := m.Get(k)
+		if !ok || (k%2 == 0) != (value == "even") {
+			t.Errorf("wrong value for key %v: %q", k, value)
+		}
+	}
+	_, ok = m.Get(count)
+	if ok {
+		t.Errorf("missing key found")
+	}
+
+	// range over all keys, and stop early
+	seen := make(map[int]bool)
+	m.Range(func(key int, value string) bool {
+		seen[key] = true
+		return true
+	})
+	if len(seen) != count {
+		t.Errorf("wrong number of keys ranged over: %v", len(seen))
+	}
+	n := 0
+	m.Range(func(key int, value string) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Errorf("range did not stop: %v", n)
+	}
+
+	// delete all odd keys
+	for k := 1; k < count; k += 2 {
+		if !m.Delete(k) {
+			t.Errorf("could not delete key %v", k)
+		}
+	}
+	if m.Delete(1) || m.Len() != count/2 {
+		t.Errorf("wrong length after delete: %v", m.Len())
+	}
+	for k := 0; k < count; k++ {
+		_, ok := m.Get(k)
+		if ok != (k%2 == 0) {
+			t.Errorf("wrong lookup result after delete for key %v", k)
+		}
+	}
+}
+
+func TestMapModel(t *testing.T) {
+
+	// compare random operations on struct keys against a builtin map
+	type key struct {
+		name string
+		id   int
+	}
+	rnd := rand.New(rand.NewSource(42))
+	m, _ := NewMap[key, int](SetRandSource(rand.NewSource(42)))
+	model := make(map[key]int)
+	for n := 0; n < 100000; n++ {
+		k := key{name: "gokoo", id: rnd.Intn(5000)}
+		switch rnd.Intn(3) {
+		case 0:
+			m.Put(k, n)
+			model[k] = n
+		case 1:
+			_, ok := model[k]
+			if m.Delete(k) != ok {
+				t.Fatalf("wrong delete result for %v", k)
+			}
+			delete(model, k)
+		case 2:
+			value, ok := m.Get(k)
+			expected, exists := model[k]
+			if ok != exists || value != expected {
+				t.Fatalf("wrong value for %v: %v != %v", k, value, expected)
+			}
+		}
+		if m.Len() != len(model) {
+			t.Fatalf("wrong length: %v != %v", m.Len(), len(model))
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !race
+
+package gokoo
+
+// raceEnabled tells tests that the race detector is running, which makes
+// sync.Pool drop items at random.
+const raceEnabled = false
@@ -0,0 +1,147 @@
+package gokoo
+
+// Fingerprint is a fingerprint stored in a cuckoo table, along with the bucket
+// and slot it is stored in. Fingerprints in the stash have slot -1. The value
+// holds the fingerprint bits in little-endian order, and copies is the number
+// of times it was inserted, which is only ever above one in counting mode.
+type Fingerprint struct {
+	Bucket int
+	Slot   int
+	Value  []byte
+	Copies int
+}
+
+// appendFingerprint will append the bytes of fingerprint f to the buffer.
+func (gt *GokooTable) appendFingerprint(buf []byte, f uint32) []byte {
+
+	for k := 0; k < (gt.fBits+7)/8; k++ {
+		buf = append(buf, byte(f>>uint(8*k)))
+	}
+
+	return buf
+}
+
+// ForEach will call the function for every fingerprint in the table, first
+// in the order of the buckets and then in the stash, until it returns false.
+// In counting mode, it is called once for every copy. The bytes are only valid
+// during the call, and the table must not be modified while walking it.
+func (gt *GokooTable) ForEach(fn func(bucket int, slot int, fp []byte) bool) {
+
+	// walk the slots directly, so we don't have to collect them first
+	buf := make([]byte, 0, 4)
+	visit := func(bucket int, slot int, v uint32) bool {
+		for c := gt.copies(v); c > 0; c-- {
+			fp := gt.appendFingerprint(buf, gt.fingerPrintOf(v))
+			if !fn(bucket, slot, fp) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i := 0; i < gt.nBuckets; i++ {
+		b := gt.bucket(i)
+		for n := 0; n < gt.nSlots; n++ {
+			v := b.get(n)
+			if v != 0 && !visit(i, n, v) {
+				return
+			}
+		}
+	}
+
+	for _, vic := range gt.stash {
+		if !visit(vic.bucket, -1, vic.f) {
+			return
+		}
+	}
+}
+
+// Scan will return the fingerprints of the buckets starting at the cursor,
+// taking whole buckets until there are at least count of them, along with the
+// cursor of the next bucket. A scan starts with cursor 0, and it is complete
+// once the returned cursor is 0 again; the stash is returned with the last
+// buckets. The table may be modified between calls, but fingerprints that
+// move in the meantime may be returned twice or not at all.
+func (gt *GokooTable) Scan(cursor uint64, count int) ([]Fingerprint,
+	uint64) {
+
+	if cursor >= uint64(gt.nBuckets) {
+		return nil, 0
+	}
+
+	// take whole buckets, so that the cursor stays valid when slots grow
+	i := int(cursor)
+	last := i
+	n := 0
+	for last < gt.nBuckets && (n < count || last == i) {
+		n += gt.bucket(last).load()
+		last++
+	}
+	next := uint64(last)
+	if last == gt.nBuckets {
+		next = 0
+	}
+
+	// every fingerprint gets its own bytes, from one shared array
+	scanned := gt.stored(i, last, next == 0)
+	size := (gt.fBits + 7) / 8
+	buf := make([]byte, 0, len(scanned)*size)
+	fps := make([]Fingerprint, len(scanned))
+	for k, sv := range scanned {
+		buf = gt.appendFingerprint(buf, gt.fingerPrintOf(sv.v))
+		fps[k] = Fingerprint{
+			Bucket: sv.bucket,
+			Slot:   sv.slot,
+			Value:  buf[k*size : (k+1)*size : (k+1)*size],
+			Copies: gt.copies(sv.v),
+		}
+	}
+
+	return fps, next
+}
+
+// slotValue is the value of a slot, including its counter, along with where
+// it is stored.
+type slotValue struct {
+	bucket int
+	slot   int
+	v      uint32
+}
+
+// stored will return the values of all used slots in the buckets from first
+// up to last, and those of the stash if requested.
+func (gt *GokooTable) stored(first int, last int, stash bool) []slotValue {
+
+	var all []slotValue
+	for i := first; i < last; i++ {
+		b := gt.bucket(i)
+		for n := 0; n < gt.nSlots; n++ {
+			v := b.get(n)
+			if v != 0 {
+				all = append(all, slotValue{bucket: i, slot: n, v: v})
+			}
+		}
+	}
+
+	if stash {
+		for _, vic := range gt.stash {
+			all = append(all, slotValue{bucket: vic.bucket, slot: -1,
+				v: vic.f})
+		}
+	}
+
+	return all
+}
+
+// Scan will return the fingerprints of the buckets starting at the cursor,
+// along with the cursor of the next bucket, as for the cuckoo table. The table
+// is only locked while the buckets are read, so a large table can be walked
+// in chunks while it is in use.
+func (ct *ConcurrentTable) Scan(cursor uint64, count int) ([]Fingerprint,
+	uint64) {
+
+	ct.table.Lock()
+	defer ct.table.Unlock()
+
+	return ct.ptr.Load().Scan(cursor, count)
+}
@@ -0,0 +1,172 @@
+package gokoo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// fingerprintKey will describe a fingerprint and where it is stored.
+func fingerprintKey(bucket int, slot int, fp []byte) string {
+	return fmt.Sprintf("%v/%v/%x", bucket, slot, fp)
+}
+
+func TestForEachScan(t *testing.T) {
+
+	// fill the table far enough to use the stash
+	gt, err := New(SetHashFunc(SipHash), SetNumBuckets(64),
+		SetFingerprintBits(12), SetStashSize(4))
+	if err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+	items := benchItems(0, 1000)
+	var inserted []GokooItem
+	for _, item := range items {
+		if gt.Insert(item) {
+			inserted = append(inserted, item)
+		}
+	}
+	if len(gt.stash) == 0 {
+		t.Fatalf("stash not in use")
+	}
+
+	// every fingerprint must be visited once, in one of its buckets
+	seen := make(map[string]int)
+	gt.ForEach(func(bucket int, slot int, fp []byte) bool {
+		if len(fp) != 2 {
+			t.Fatalf("wrong fingerprint length: %v", len(fp))
+		}
+		if slot < -1 || slot >= gt.nSlots {
+			t.Fatalf("wrong slot: %v", slot)
+		}
+		seen[fingerprintKey(bucket, slot, fp)]++
+		return true
+	})
+	if len(seen) != len(inserted) {
+		t.Errorf("wrong number of fingerprints: %v != %v", len(seen),
+			len(inserted))
+	}
+	for _, item := range inserted {
+		f, i1, i2 := gt.locate(&hashed{data: item.Bytes()})
+		fp := binary.LittleEndian.AppendUint16(nil, uint16(f))
+		found := false
+		for _, i := range []int{i1, i2} {
+			for slot := -1; slot < gt.nSlots; slot++ {
+				found = found || seen[fingerprintKey(i, slot, fp)] > 0
+			}
+		}
+		if !found {
+			t.Errorf("fingerprint of item not visited")
+		}
+	}
+
+	// the walk must stop when asked to
+	visited := 0
+	gt.ForEach(func(bucket int, slot int, fp []byte) bool {
+		visited++
+		return visited < 10
+	})
+	if visited != 10 {
+		t.Errorf("walk did not stop: %v", visited)
+	}
+
+	// scanning in chunks must return the same fingerprints
+	for _, count := range []int{0, 1, 37, 10000} {
+		scanned := make(map[string]int)
+		cursor := uint64(0)
+		for {
+			fps, next := gt.Scan(cursor, count)
+			if next != 0 && len(fps) < count {
+				t.Errorf("chunk too small: %v < %v", len(fps), count)
+			}
+			for _, fp := range fps {
+				if fp.Copies != 1 {
+					t.Errorf("wrong number of copies: %v", fp.Copies)
+				}
+				scanned[fingerprintKey(fp.Bucket, fp.Slot, fp.Value)]++
+			}
+			if next == 0 {
+				break
+			}
+			if next <= cursor {
+				t.Fatalf("cursor did not advance: %v", next)
+			}
+			cursor = next
+		}
+		if fmt.Sprint(scanned) != fmt.Sprint(seen) {
+			t.Errorf("scan with count %v differs from walk", count)
+		}
+	}
+
+	// cursors beyond the buckets end the scan
+	fps, next := gt.Scan(64, 10)
+	if len(fps) != 0 || next != 0 {
+		t.Errorf("scan beyond buckets returned %v, %v", len(fps), next)
+	}
+}
+
+func TestForEachCounting(t *testing.T) {
+
+	gt, err := New(SetHashFunc(SipHash), SetNumBuckets(64), SetCounting(true))
+	if err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+	item := benchItems(0, 1)[0]
+	for n := 0; n < 3; n++ {
+		gt.Insert(item)
+	}
+
+	// the walk visits every copy, while a scan returns the slot once
+	visited := 0
+	gt.ForEach(func(bucket int, slot int, fp []byte) bool {
+		visited++
+		return true
+	})
+	if visited != 3 {
+		t.Errorf("wrong number of copies visited: %v", visited)
+	}
+	fps, next := gt.Scan(0, 100)
+	if len(fps) != 1 || fps[0].Copies != 3 || next != 0 {
+		t.Errorf("wrong scan result: %+v, %v", fps, next)
+	}
+}
+
+func TestConcurrentScan(t *testing.T) {
+
+	ct, err := NewConcurrent(SetHashFunc(SipHash), SetNumBuckets(1024),
+		SetRebuild(true))
+	if err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+	items := benchItems(0, 10000)
+	for _, item := range items[:5000] {
+		ct.Insert(item)
+	}
+
+	// the scan must run to the end while other items are inserted and the
+	// table rebuilds, although moved fingerprints may be missed or repeated
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, item := range items[5000:] {
+			ct.Insert(item)
+		}
+	}()
+	scanned := 0
+	cursor := uint64(0)
+	for {
+		fps, next := ct.Scan(cursor, 100)
+		scanned += len(fps)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	wg.Wait()
+
+	if scanned < 1000 {
+		t.Errorf("wrong number of fingerprints scanned: %v", scanned)
+	}
+}
@@ -0,0 +1,284 @@
+package gokoo
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+	"math/rand"
+	"sync/atomic"
+)
+
+// ShardedTable is a cuckoo filter split into several independent concurrent
+// tables, which items are routed to by the highest bits of their hash. Every
+// shard has its own storage and locks, and rebuilds or takes snapshots on its
+// own, so very large filters don't need a single huge allocation and don't
+// stall as a whole.
+type ShardedTable struct {
+	shards []*ConcurrentTable
+	router atomic.Pointer[router]
+}
+
+// router is the hash function that items are routed to shards by, which is
+// replaced along with the shards when they are restored together.
+type router struct {
+	hash64 GokooHash64
+	keyed  bool
+	k0     uint64
+	k1     uint64
+	id     byte
+	shift  uint
+}
+
+// newRouter will return a router using the hash function of the table, taking
+// the shard bits from the top of the hash. They must not overlap with the bits
+// for the index, including its spare bits, and the fingerprint.
+func newRouter(gt *GokooTable, nShards int) (*router, error) {
+
+	sBits := bits.Len(uint(nShards - 1))
+	if gt.hashBits() < gt.iBits+gt.xBits+gt.fBits+sBits {
+		return nil, errors.New("hash bit length insufficient for given" +
+			" number of shards")
+	}
+
+	rt := &router{
+		hash64: gt.hash64,
+		keyed:  gt.keyed,
+		k0:     gt.k0,
+		k1:     gt.k1,
+		id:     hashID(gt.hash64),
+		shift:  uint(gt.hashBits() - sBits),
+	}
+
+	return rt, nil
+}
+
+// hashes will check if the table uses the hash function of the router. Custom
+// hash functions can't be compared, but a shard always keeps the one it was
+// created with.
+func (rt *router) hashes(gt *GokooTable) bool {
+
+	if gt.keyed || rt.keyed {
+		return gt.keyed == rt.keyed && gt.k0 == rt.k0 && gt.k1 == rt.k1
+	}
+
+	return hashID(gt.hash64) == rt.id
+}
+
+// NewSharded will create a new sharded cuckoo filter with the given number of
+// shards, which has to be a power of two. The options apply to every shard,
+// so the number of buckets is per shard; all shards use the same hash
+// function, and the same keys if they are random.
+func NewSharded(nShards int, options ...func(*GokooTable)) (*ShardedTable,
+	error) {
+
+	if nShards < 1 || nShards&(nShards-1) != 0 {
+		return nil, errors.New("number of shards must be a power of two")
+	}
+
+	first, err := NewConcurrent(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	gt := first.ptr.Load()
+	rt, err := newRouter(gt, nShards)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &ShardedTable{
+		shards: []*ConcurrentTable{first},
+	}
+	st.router.Store(rt)
+
+	// the other shards reuse random keys, and get their own random sources
+	// derived from the first one, so inserts stay reproducible
+	for len(st.shards) < nShards {
+		shardOptions := append([]func(*GokooTable){}, options...)
+		if gt.keyed {
+			shardOptions = append(shardOptions, SetSipHashKeys(gt.k0, gt.k1))
+		}
+		shardOptions = append(shardOptions,
+			SetRandSource(rand.NewSource(gt.rnd.Int63())))
+		ct, err := NewConcurrent(shardOptions...)
+		if err != nil {
+			return nil, err
+		}
+		st.shards = append(st.shards, ct)
+	}
+
+	return st, nil
+}
+
+// route will return the shard for the hashed item, and hash it for the
+// current table of that shard.
+func (st *ShardedTable) route(h *hashed) *ConcurrentTable {
+
+	// all shards share the hash function, so we usually only hash once; a
+	// shard restored on its own from a table with another hash function
+	// hashes the item again
+	rt := st.router.Load()
+	hash := rt.hash64(h.data)
+	ct := st.shards[hash>>rt.shift&uint64(len(st.shards)-1)]
+	gt := ct.ptr.Load()
+	if rt.hashes(gt) {
+		h.hash = hash
+		h.gt = gt
+	}
+
+	return ct
+}
+
+// Insert will try to add an item to the shard it belongs to.
+func (st *ShardedTable) Insert(item GokooItem) bool {
+
+	h := &hashed{data: item.Bytes()}
+	return st.route(h).insert(h)
+}
+
+// Lookup will check if the shard the item belongs to contains it.
+func (st *ShardedTable) Lookup(item GokooItem) bool {
+
+	h := &hashed{data: item.Bytes()}
+	return st.route(h).lookup(h)
+}
+
+// Remove will remove an item from the shard it belongs to.
+func (st *ShardedTable) Remove(item GokooItem) bool {
+
+	h := &hashed{data: item.Bytes()}
+	return st.route(h).remove(h)
+}
+
+// CountItem will return how many times the item was inserted into its shard,
+// which is only ever more than one in counting mode.
+func (st *ShardedTable) CountItem(item GokooItem) int {
+
+	h := &hashed{data: item.Bytes()}
+	return st.route(h).countItem(h)
+}
+
+// Count will return the number of items stored in all shards.
+func (st *ShardedTable) Count() int {
+
+	count := 0
+	for _, ct := range st.shards {
+		count += ct.Count()
+	}
+
+	return count
+}
+
+// NumShards will return the number of shards.
+func (st *ShardedTable) NumShards() int {
+	return len(st.shards)
+}
+
+// Shard will return shard k, so it can be inspected, snapshot or restored on
+// its own. A shard must only be restored from a snapshot of the same shard,
+// or its items won't be found. Items are routed by the hash function of the
+// sharded table, so shards using random keys have to be restored together by
+// ReadFrom.
+func (st *ShardedTable) Shard(k int) *ConcurrentTable {
+	return st.shards[k]
+}
+
+// shardedMagic is written at the start of every serialized sharded table.
+var shardedMagic = [4]byte{'G', 'O', 'K', 'S'}
+
+// shardedHeader is the fixed size part at the start of a serialized sharded
+// table.
+type shardedHeader struct {
+	Magic   [4]byte
+	Version byte
+	NShards uint64
+}
+
+// WriteTo will write all shards to the writer, after a header with their
+// number. Each shard is written as a snapshot of its own, including the keys
+// of a keyed hash function, which items are routed by as well.
+func (st *ShardedTable) WriteTo(w io.Writer) (int64, error) {
+
+	cw := &countWriter{w: w}
+	h := shardedHeader{
+		Magic:   shardedMagic,
+		Version: version,
+		NShards: uint64(len(st.shards)),
+	}
+	err := binary.Write(cw, binary.LittleEndian, &h)
+	if err != nil {
+		return cw.n, err
+	}
+
+	for _, ct := range st.shards {
+		_, err = ct.WriteTo(cw)
+		if err != nil {
+			return cw.n, err
+		}
+	}
+
+	return cw.n, nil
+}
+
+// ReadFrom will replace all shards with the ones read from the reader, and
+// route items by their hash function from then on, so a table with random
+// keys can be restored in another process. The number of shards has to be
+// the same, and the table is only modified if all shards could be read.
+func (st *ShardedTable) ReadFrom(r io.Reader) (int64, error) {
+
+	cr := &countReader{r: r}
+	var h shardedHeader
+	err := binary.Read(cr, binary.LittleEndian, &h)
+	if err != nil {
+		return cr.n, err
+	}
+	if h.Magic != shardedMagic {
+		return cr.n, errors.New("data is not a serialized sharded table")
+	}
+	if h.Version != version {
+		return cr.n, errors.New("unsupported serialization version")
+	}
+	if h.NShards != uint64(len(st.shards)) {
+		return cr.n, errors.New("serialized table has different number of" +
+			" shards")
+	}
+
+	// lock all shards in order, so the restore is atomic
+	for _, ct := range st.shards {
+		ct.table.Lock()
+		defer ct.table.Unlock()
+	}
+
+	// read all shards before switching over to any of them
+	tables := make([]*GokooTable, len(st.shards))
+	for k, ct := range st.shards {
+		tables[k], _, err = ct.read(cr)
+		if err != nil {
+			return cr.n, err
+		}
+	}
+
+	// all shards must share the hash function they are routed by, and leave
+	// room for the shard bits
+	rt, err := newRouter(tables[0], len(tables))
+	if err != nil {
+		return cr.n, err
+	}
+	for _, gt := range tables[1:] {
+		_, err = newRouter(gt, len(tables))
+		if err != nil {
+			return cr.n, err
+		}
+		if !rt.hashes(gt) {
+			return cr.n, errors.New("shards use different hash functions")
+		}
+	}
+
+	for k, ct := range st.shards {
+		ct.swap(tables[k])
+	}
+	st.router.Store(rt)
+
+	return cr.n, nil
+}
@@ -0,0 +1,237 @@
+package gokoo
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestNewSharded(t *testing.T) {
+
+	for _, nShards := range []int{0, 3, -4} {
+		_, err := NewSharded(nShards, SetHashFunc(SipHash))
+		if err == nil {
+			t.Errorf("invalid number of shards accepted: %v", nShards)
+		}
+	}
+
+	// the shard bits must fit above the index and fingerprint bits
+	short := func(input []byte) []byte { return SipHash(input)[:4] }
+	_, err := NewSharded(4, SetHashFunc(short), SetNumBuckets(1<<16),
+		SetFingerprintBits(16))
+	if err == nil {
+		t.Errorf("overlapping shard bits accepted")
+	}
+
+	// random keys must be shared between all shards
+	st, err := NewSharded(4, SetRandomSipHashKeys())
+	if err != nil {
+		t.Fatalf("could not create sharded table: %v", err)
+	}
+	first := st.Shard(0).ptr.Load()
+	for k := 1; k < st.NumShards(); k++ {
+		gt := st.Shard(k).ptr.Load()
+		if !gt.keyed || gt.k0 != first.k0 || gt.k1 != first.k1 {
+			t.Errorf("shard %v has different keys", k)
+		}
+	}
+}
+
+func TestSharded(t *testing.T) {
+
+	st, err := NewSharded(8, SetHashFunc(SipHash), SetNumBuckets(1024),
+		SetFingerprintBits(16))
+	if err != nil {
+		t.Fatalf("could not create sharded table: %v", err)
+	}
+	items := benchItems(0, 20000)
+	for _, item := range items {
+		if !st.Insert(item) {
+			t.Fatalf("insert error")
+		}
+	}
+	if st.Count() != len(items) {
+		t.Errorf("wrong count: %v != %v", st.Count(), len(items))
+	}
+
+	// items must be spread evenly over the shards
+	for k := 0; k < st.NumShards(); k++ {
+		count := st.Shard(k).Count()
+		if count < 2000 || count > 3000 {
+			t.Errorf("shard %v holds %v items", k, count)
+		}
+	}
+
+	// other items can share the fingerprint and buckets of an item, and are
+	// counted along with it
+	collisions := 0
+	for _, item := range items {
+		if !st.Lookup(item) || st.CountItem(item) < 1 {
+			t.Fatalf("lookup error")
+		}
+		if st.CountItem(item) > 1 {
+			collisions++
+		}
+	}
+	if collisions > 10 {
+		t.Errorf("too many fingerprint collisions: %v", collisions)
+	}
+	positives := 0
+	for _, item := range benchItems(1<<40, 10000) {
+		if st.Lookup(item) {
+			positives++
+		}
+	}
+	if positives > 10 {
+		t.Errorf("too many false positives: %v", positives)
+	}
+
+	// a shard restored from its own snapshot must still find its items
+	var buf bytes.Buffer
+	_, err = st.Shard(3).WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("could not write shard: %v", err)
+	}
+	_, err = st.Shard(3).ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("could not read shard: %v", err)
+	}
+
+	for _, item := range items {
+		if !st.Remove(item) {
+			t.Fatalf("remove error")
+		}
+	}
+	if st.Count() != 0 {
+		t.Errorf("items left after removing all: %v", st.Count())
+	}
+}
+
+func TestShardedRestoreShard(t *testing.T) {
+
+	// a shard restored from a table with another hash function must use it
+	// instead of the one items are routed by
+	st, err := NewSharded(1, SetHashFunc(SipHash))
+	if err != nil {
+		t.Fatalf("could not create sharded table: %v", err)
+	}
+	gt, err := New(SetHashFunc(Sha256Hash), SetNumBuckets(64))
+	if err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+	items := benchItems(0, 100)
+	for _, item := range items {
+		if !gt.Insert(item) {
+			t.Fatalf("insert error")
+		}
+	}
+	data, _ := gt.MarshalBinary()
+	_, err = st.Shard(0).ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not read shard: %v", err)
+	}
+	for _, item := range items {
+		if !st.Lookup(item) {
+			t.Fatalf("lookup error in restored shard")
+		}
+	}
+}
+
+func TestShardedRebuild(t *testing.T) {
+
+	// every shard rebuilds on its own as it fills up
+	st, err := NewSharded(4, SetHashFunc(SipHash), SetNumBuckets(64),
+		SetFingerprintBits(16), SetRebuild(true))
+	if err != nil {
+		t.Fatalf("could not create sharded table: %v", err)
+	}
+	items := benchItems(0, 10000)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(part []GokooItem) {
+			defer wg.Done()
+			for _, item := range part {
+				if !st.Insert(item) {
+					t.Errorf("insert error")
+				}
+			}
+		}(items[w*2500 : (w+1)*2500])
+	}
+	wg.Wait()
+
+	for k := 0; k < st.NumShards(); k++ {
+		if st.Shard(k).ptr.Load().nSlots == 4 {
+			t.Errorf("shard %v was not rebuilt", k)
+		}
+	}
+	for _, item := range items {
+		if !st.Lookup(item) {
+			t.Fatalf("lookup error after rebuild")
+		}
+	}
+}
+
+func TestShardedWriteToReadFrom(t *testing.T) {
+
+	// a table with random keys restored in another process gets new keys,
+	// and has to take over the ones from the snapshot to route items
+	st, err := NewSharded(4, SetRandomSipHashKeys(), SetNumBuckets(256))
+	if err != nil {
+		t.Fatalf("could not create sharded table: %v", err)
+	}
+	items := benchItems(0, 2000)
+	for _, item := range items {
+		if !st.Insert(item) {
+			t.Fatalf("insert error")
+		}
+	}
+	var buf bytes.Buffer
+	nWrite, err := st.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("could not write sharded table: %v", err)
+	}
+	data := append([]byte(nil), buf.Bytes()...)
+
+	st2, err := NewSharded(4, SetRandomSipHashKeys(), SetNumBuckets(256))
+	if err != nil {
+		t.Fatalf("could not create sharded table: %v", err)
+	}
+	nRead, err := st2.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("could not read sharded table: %v", err)
+	}
+	if nRead != nWrite || buf.Len() != 0 {
+		t.Errorf("wrong number of bytes read: %v != %v", nRead, nWrite)
+	}
+	if st2.Count() != len(items) {
+		t.Errorf("wrong count after restore: %v", st2.Count())
+	}
+	for _, item := range items {
+		if !st2.Lookup(item) {
+			t.Fatalf("lookup error after restore")
+		}
+	}
+	if !st2.Insert(benchItems(1<<40, 1)[0]) ||
+		!st2.Lookup(benchItems(1<<40, 1)[0]) {
+		t.Errorf("insert error after restore")
+	}
+
+	// a different number of shards or a partial snapshot must be rejected
+	// without changing the table
+	st3, err := NewSharded(2, SetRandomSipHashKeys(), SetNumBuckets(256))
+	if err != nil {
+		t.Fatalf("could not create sharded table: %v", err)
+	}
+	_, err = st3.ReadFrom(bytes.NewReader(data))
+	if err == nil {
+		t.Errorf("different number of shards accepted")
+	}
+	_, err = st2.ReadFrom(bytes.NewReader(data[:len(data)-1]))
+	if err == nil {
+		t.Errorf("truncated snapshot accepted")
+	}
+	if st2.Count() != len(items)+1 {
+		t.Errorf("failed restore changed the table: %v", st2.Count())
+	}
+}
@@ -0,0 +1,96 @@
+package gokoo
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// Sizing describes the dimensions chosen for a cuckoo table, and what we can
+// expect from it once it holds the expected number of items.
+type Sizing struct {
+	NumBuckets      int     // number of buckets, always a power of two
+	NumSlots        int     // number of slots per bucket
+	FingerprintBits int     // number of bits per fingerprint
+	Bytes           int     // memory used by the buckets
+	FPRate          float64 // estimated false positive rate when filled
+}
+
+// maxLoad holds the load factors that can be reached with high probability
+// before inserts start to fail, for a given number of slots per bucket, as
+// measured in the cuckoo filter paper.
+var maxLoad = map[int]float64{
+	2: 0.84,
+	4: 0.95,
+	8: 0.98,
+}
+
+// NewForCapacity will create a new cuckoo filter that can hold the expected
+// number of items while keeping its false positive rate below the given one.
+// It follows the cuckoo filter paper: two slots per bucket for rates above
+// 0.2%, four slots for rates down to 0.001% and eight below, and just enough
+// fingerprint bits to reach the rate at full load. The options are applied
+// after the sizing, so they can override it, and the returned sizing always
+// describes the table that was actually created.
+func NewForCapacity(expectedItems int, falsePositiveRate float64,
+	options ...func(*GokooTable)) (*GokooTable, Sizing, error) {
+
+	if expectedItems < 1 {
+		return nil, Sizing{}, errors.New("expected items must be positive")
+	}
+
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, Sizing{}, errors.New("false positive rate must be between" +
+			" zero and one")
+	}
+
+	// bigger buckets allow a higher load, but need longer fingerprints for
+	// the same rate, which only pays off for low rates
+	nSlots := 8
+	if falsePositiveRate > 0.002 {
+		nSlots = 2
+	} else if falsePositiveRate > 0.00001 {
+		nSlots = 4
+	}
+
+	// find the shortest fingerprint that reaches the rate with full buckets
+	fBits := 4
+	for estimateFPRate(nSlots, fBits, 1) > falsePositiveRate {
+		fBits++
+	}
+	if fBits > 32 {
+		return nil, Sizing{}, errors.New("false positive rate too low for" +
+			" 32 bit fingerprints")
+	}
+
+	// round the number of buckets needed at the maximum load up to a power
+	// of two
+	perBucket := float64(nSlots) * maxLoad[nSlots]
+	need := int(math.Ceil(float64(expectedItems) / perBucket))
+	nBuckets := 1 << bits.Len(uint(need-1))
+
+	sized := []func(*GokooTable){
+		SetNumBuckets(nBuckets),
+		SetNumSlots(nSlots),
+		SetFingerprintBits(fBits),
+	}
+	gt, err := New(append(sized, options...)...)
+	if err != nil {
+		return nil, Sizing{}, err
+	}
+
+	// describe the table we created, with the expected items spread evenly
+	used := float64(expectedItems) / float64(gt.nBuckets*gt.nSlots)
+	if used > 1 {
+		used = 1
+	}
+	sizing := Sizing{
+		NumBuckets:      gt.nBuckets,
+		NumSlots:        gt.nSlots,
+		FingerprintBits: gt.fBits,
+		Bytes:           len(gt.buckets) + 8*len(gt.words),
+		FPRate:          estimateFPRate(gt.nSlots, gt.fBits, used),
+	}
+
+	return gt, sizing, nil
+}
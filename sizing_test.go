@@ -0,0 +1,80 @@
+package gokoo
+
+import (
+	"testing"
+)
+
+func TestNewForCapacity(t *testing.T) {
+
+	// check rates that lead to each of the bucket sizes
+	count := 10000
+	items := benchItems(0, count)
+	absent := benchItems(1<<40, 100000)
+	for _, rate := range []float64{0.03, 0.001, 0.000001} {
+		gt, sizing, err := NewForCapacity(count, rate, SetHashFunc(SipHash))
+		if err != nil {
+			t.Fatalf("could not create table for rate %v: %v", rate, err)
+		}
+
+		// the sizing must describe the table
+		if sizing.NumBuckets != gt.nBuckets || sizing.NumSlots != gt.nSlots ||
+			sizing.FingerprintBits != gt.fBits {
+			t.Errorf("sizing does not match table: %+v", sizing)
+		}
+		if sizing.NumBuckets&(sizing.NumBuckets-1) != 0 {
+			t.Errorf("number of buckets not a power of two: %v",
+				sizing.NumBuckets)
+		}
+		if sizing.Bytes != len(gt.buckets) {
+			t.Errorf("wrong memory size: %v != %v", sizing.Bytes,
+				len(gt.buckets))
+		}
+		if sizing.FPRate > rate {
+			t.Errorf("estimated rate above requested one: %v > %v",
+				sizing.FPRate, rate)
+		}
+
+		// all expected items must fit
+		for _, item := range items {
+			if !gt.Insert(item) {
+				t.Fatalf("insert error for rate %v", rate)
+			}
+		}
+
+		// the measured rate must stay below the requested one, allowing for
+		// sampling noise
+		positives := 0
+		for _, item := range absent {
+			if gt.Lookup(item) {
+				positives++
+			}
+		}
+		measured := float64(positives) / float64(len(absent))
+		if measured > 1.2*rate+10/float64(len(absent)) {
+			t.Errorf("false positive rate above requested one: %v > %v",
+				measured, rate)
+		}
+	}
+
+	// options are applied after the sizing
+	_, sizing, err := NewForCapacity(1000, 0.01, SetNumSlots(3),
+		SetAtomicBuckets(true))
+	if err != nil {
+		t.Fatalf("could not create table with options: %v", err)
+	}
+	if sizing.NumSlots != 3 || sizing.Bytes != 8*sizing.NumBuckets {
+		t.Errorf("options not reflected in sizing: %+v", sizing)
+	}
+
+	// invalid parameters must be rejected
+	for _, rate := range []float64{0, 1, -0.5, 1e-12} {
+		_, _, err := NewForCapacity(1000, rate)
+		if err == nil {
+			t.Errorf("could create table for rate %v", rate)
+		}
+	}
+	_, _, err = NewForCapacity(0, 0.01)
+	if err == nil {
+		t.Errorf("could create table for no items")
+	}
+}
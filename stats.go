@@ -0,0 +1,108 @@
+package gokoo
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Stats holds statistics about the contents and usage of a cuckoo table.
+type Stats struct {
+	Count      int     // number of items in the buckets and the stash
+	Capacity   int     // number of items that fit into the buckets and stash
+	LoadFactor float64 // ratio of count to capacity
+	BucketFill []int   // number of buckets with a given number of used slots
+	AvgKicks   float64 // average length of eviction chains during inserts
+	Failed     int     // number of inserts that failed
+	FPRate     float64 // estimated false positive rate at the current load
+}
+
+// Count will return the number of items stored in the cuckoo table, including
+// all copies counted in counting mode.
+func (gt *GokooTable) Count() int {
+
+	nItems := atomic.LoadInt64(&gt.nItems) + atomic.LoadInt64(&gt.nDups)
+	return int(nItems) + len(gt.stash)
+}
+
+// Capacity will return the number of items the cuckoo table can hold in its
+// buckets and stash.
+func (gt *GokooTable) Capacity() int {
+	return gt.nBuckets*gt.nSlots + gt.nStash
+}
+
+// LoadFactor will return the ratio of used slots to capacity, which is the
+// ratio of stored items unless copies are counted in counting mode.
+func (gt *GokooTable) LoadFactor() float64 {
+
+	used := int(atomic.LoadInt64(&gt.nItems)) + len(gt.stash)
+	return float64(used) / float64(gt.Capacity())
+}
+
+// Stats will return statistics about the cuckoo table. The kick and failure
+// counters start at zero when a table is created or read.
+func (gt *GokooTable) Stats() Stats {
+
+	// copy the histogram so the caller can't modify ours
+	fill := make([]int, len(gt.fill))
+	for k := range gt.fill {
+		fill[k] = int(atomic.LoadInt64(&gt.fill[k]))
+	}
+
+	// eviction chains are only started when both buckets are full
+	avgKicks := 0.0
+	nChains := atomic.LoadInt64(&gt.nChains)
+	if nChains > 0 {
+		avgKicks = float64(atomic.LoadInt64(&gt.nKicks)) / float64(nChains)
+	}
+
+	// estimate the false positive rate from the current load
+	nItems := atomic.LoadInt64(&gt.nItems)
+	used := float64(nItems) / float64(gt.nBuckets*gt.nSlots)
+	fpRate := estimateFPRate(gt.nSlots, gt.fBits, used)
+
+	return Stats{
+		Count:      gt.Count(),
+		Capacity:   gt.Capacity(),
+		LoadFactor: gt.LoadFactor(),
+		BucketFill: fill,
+		AvgKicks:   avgKicks,
+		Failed:     gt.nFailed,
+		FPRate:     fpRate,
+	}
+}
+
+// estimateFPRate will estimate the false positive rate of a lookup, where
+// both buckets have the given share of their slots in use.
+func estimateFPRate(nSlots int, fBits int, used float64) float64 {
+
+	// a lookup compares against the used slots of two buckets, and each of
+	// them matches with a chance of one in all non-empty fingerprints
+	match := 1 / (math.Exp2(float64(fBits)) - 1)
+	return 1 - math.Pow(1-match, 2*float64(nSlots)*used)
+}
+
+// recount will reset all statistics and count the items in the buckets.
+func (gt *GokooTable) recount() {
+
+	gt.nItems = 0
+	gt.nDups = 0
+	gt.nChains = 0
+	gt.nKicks = 0
+	gt.nFailed = 0
+	gt.nStashed = int64(len(gt.stash))
+	gt.fill = make([]int64, gt.nSlots+1)
+	for i := 0; i < gt.nBuckets; i++ {
+		b := gt.bucket(i)
+		k := b.load()
+		gt.fill[k]++
+		gt.nItems += int64(k)
+		for n := 0; n < gt.nSlots; n++ {
+			if b.used(n) {
+				gt.nDups += int64(gt.copies(b.get(n)) - 1)
+			}
+		}
+	}
+	for _, vic := range gt.stash {
+		gt.nDups += int64(gt.copies(vic.f) - 1)
+	}
+}
@@ -0,0 +1,89 @@
+package gokoo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCount(t *testing.T) {
+
+	// create an empty table
+	gt, _ := New(SetNumBuckets(64), SetStashSize(2))
+	if gt.Count() != 0 || gt.LoadFactor() != 0 {
+		t.Errorf("new table not empty")
+	}
+	if gt.Capacity() != 64*4+2 {
+		t.Errorf("wrong capacity: %v", gt.Capacity())
+	}
+
+	// insert items and check the count
+	count := 100
+	items := randomItems(t, count)
+	for _, item := range items {
+		gt.Insert(item)
+	}
+	if gt.Count() != count {
+		t.Errorf("wrong count after insert: %v != %v", gt.Count(), count)
+	}
+	load := float64(count) / float64(gt.Capacity())
+	if gt.LoadFactor() != load {
+		t.Errorf("wrong load factor: %v != %v", gt.LoadFactor(), load)
+	}
+
+	// remove half of the items and check the count
+	for _, item := range items[:count/2] {
+		gt.Remove(item)
+	}
+	if gt.Count() != count/2 {
+		t.Errorf("wrong count after remove: %v != %v", gt.Count(), count/2)
+	}
+}
+
+func TestStats(t *testing.T) {
+
+	// fill a table until inserts start to fail
+	count := 1000
+	items := randomItems(t, count)
+	gt, _ := New(SetNumBuckets(64), SetNumTries(32), SetStashSize(1))
+	inserted := 0
+	for _, item := range items {
+		if gt.Insert(item) {
+			inserted++
+		}
+	}
+
+	// check the counters kept during inserts
+	stats := gt.Stats()
+	if stats.Count != inserted {
+		t.Errorf("wrong count: %v != %v", stats.Count, inserted)
+	}
+	if stats.Failed != count-inserted {
+		t.Errorf("wrong failed inserts: %v != %v", stats.Failed, count-inserted)
+	}
+	if stats.AvgKicks <= 0 {
+		t.Errorf("no evictions counted on full table")
+	}
+	if stats.FPRate <= 0 || stats.FPRate > 2*4/255.0 {
+		t.Errorf("estimated false positive rate out of bounds: %v", stats.FPRate)
+	}
+
+	// the incremental histogram must match a full count of the buckets
+	fill := stats.BucketFill
+	gt.recount()
+	counted := gt.Stats().BucketFill
+	if !reflect.DeepEqual(fill, counted) {
+		t.Errorf("bucket fill mismatch: %v != %v", fill, counted)
+	}
+
+	// the histogram must still match after removing and growing
+	for _, item := range items[:count/2] {
+		gt.Remove(item)
+	}
+	gt.grow()
+	fill = gt.Stats().BucketFill
+	gt.recount()
+	counted = gt.Stats().BucketFill
+	if !reflect.DeepEqual(fill, counted) {
+		t.Errorf("bucket fill mismatch: %v != %v", fill, counted)
+	}
+}